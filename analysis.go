@@ -0,0 +1,172 @@
+package ttt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// What a move leads to with best play from both sides
+type Value int
+
+const (
+	ValueLoss Value = iota - 1
+	ValueDraw
+	ValueWin
+)
+
+func (v Value) String() string {
+	switch v {
+	case ValueWin:
+		return "win"
+	case ValueLoss:
+		return "loss"
+	}
+	return "draw"
+}
+
+// A legal move and where it leads
+type MoveAnalysis struct {
+	Pos   Position `json:"position"`
+	Value Value    `json:"value"`
+	// Moves of both players until the game is won, this one included.
+	// 0 for draws.
+	Plies int `json:"plies,omitempty"`
+}
+
+// Like "win in 2", counting the moves of the winner
+func (m MoveAnalysis) String() string {
+	if m.Value == ValueDraw {
+		return m.Value.String()
+	}
+	return fmt.Sprintf("%s in %d", m.Value, (m.Plies+1)/2)
+}
+
+// The search score of the move: quicker wins and slower losses are
+// worth more
+func (m MoveAnalysis) score() int {
+	switch m.Value {
+	case ValueWin:
+		return WinScore - m.Plies
+	case ValueLoss:
+		return -(WinScore - m.Plies)
+	}
+	return 0
+}
+
+// Whether m is a better move than o
+func (m MoveAnalysis) Better(o MoveAnalysis) bool {
+	return m.score() > o.score()
+}
+
+func moveAnalysis(p Position, score int) MoveAnalysis {
+	switch {
+	case score > 0:
+		return MoveAnalysis{p, ValueWin, WinScore - score}
+	case score < 0:
+		return MoveAnalysis{p, ValueLoss, WinScore + score}
+	}
+	return MoveAnalysis{Pos: p, Value: ValueDraw}
+}
+
+type Analysis []MoveAnalysis
+
+// The moves no other move is better than
+func (a Analysis) Best() Analysis {
+	best := Analysis{}
+	for _, m := range a {
+		switch {
+		case len(best) == 0 || m.Better(best[0]):
+			best = Analysis{m}
+		case !best[0].Better(m):
+			best = append(best, m)
+		}
+	}
+	return best
+}
+
+// The analysis of a move, if it is legal
+func (a Analysis) Get(p Position) (MoveAnalysis, bool) {
+	for _, m := range a {
+		if m.Pos == p {
+			return m, true
+		}
+	}
+	return MoveAnalysis{}, false
+}
+
+// Search every legal move of the player to move on b to the end of the
+// game, row by row. Big boards take long: the error of ctx is returned
+// if it is done first.
+func AnalyzeBoard(ctx context.Context, b *Board) (Analysis, error) {
+	s := newSearcher(ctx)
+	moves := b.LegalMoves()
+	a := Analysis{}
+	nb := playableOf(b)
+	nodes := 0
+	for _, p := range moves {
+		nb.Play(p)
+		v, ok := s.negamax(nb, len(moves), 1, -WinScore, WinScore, &nodes)
+		nb.Undo(p)
+		if !ok {
+			return nil, ctx.Err()
+		}
+		a = append(a, moveAnalysis(p, -v))
+	}
+	return a, nil
+}
+
+// Moves row by row
+type byRow Analysis
+
+func (a byRow) Len() int      { return len(a) }
+func (a byRow) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byRow) Less(i, j int) bool {
+	p, q := a[i].Pos, a[j].Pos
+	return p.Y < q.Y || p.Y == q.Y && p.X < q.X
+}
+
+// A grid and the player to move on it
+type positionKey struct {
+	grid   Grid
+	toMove Mark
+}
+
+// Transposition table of the analyses of canonical grids, shared by all
+// grids which turn or flip into them
+var (
+	analyses    = make(map[positionKey]Analysis)
+	analysesMux sync.Mutex
+)
+
+// Every legal move of the current player, row by row
+func (g Game) Analyze() Analysis {
+	canonical, sym := g.Grd.Canonical()
+	key := positionKey{canonical, g.CurrentPlayer}
+	analysesMux.Lock()
+	a, ok := analyses[key]
+	analysesMux.Unlock()
+	if !ok {
+		cg := g
+		cg.Grd = canonical
+		a, _ = AnalyzeBoard(context.Background(), BoardFromGame(cg))
+		analysesMux.Lock()
+		analyses[key] = a
+		analysesMux.Unlock()
+	}
+	inverse := sym.Inverse()
+	mapped := make(Analysis, len(a))
+	for i, m := range a {
+		m.Pos = inverse.Apply(m.Pos, Size)
+		mapped[i] = m
+	}
+	sort.Sort(byRow(mapped))
+	return mapped
+}
+
+// A best move for the current player, the first one row by row. The
+// game must not be over.
+func (g Game) Hint() Position {
+	return g.Analyze().Best()[0].Pos
+}
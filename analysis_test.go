@@ -0,0 +1,64 @@
+package ttt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGameAnalyze(t *testing.T) {
+	// X wins at once in the top row, or O does in the middle one
+	g, err := ParseGame("XX1/OO1/3 X")
+	assert.Nil(t, err)
+	a := g.Analyze()
+	assert.Equal(t, len(a), 5)
+	m, ok := a.Get(Position{2, 0})
+	assert.True(t, ok)
+	assert.Equal(t, m, MoveAnalysis{Position{2, 0}, ValueWin, 1})
+	assert.Equal(t, m.String(), "win in 1")
+	m, _ = a.Get(Position{0, 2})
+	assert.Equal(t, m, MoveAnalysis{Position{0, 2}, ValueLoss, 2})
+	assert.Equal(t, m.String(), "loss in 1")
+	_, ok = a.Get(Position{0, 0})
+	assert.False(t, ok)
+	assert.Equal(t, a.Best(), Analysis{{Position{2, 0}, ValueWin, 1}})
+}
+
+func TestGameAnalyzeTies(t *testing.T) {
+	g := Game{CurrentPlayer: MarkX, NextPlayer: MarkO}
+	a := g.Analyze()
+	assert.Equal(t, len(a), 9)
+	assert.Equal(t, len(a.Best()), 9)
+	for _, m := range a {
+		assert.Equal(t, m.String(), "draw")
+	}
+
+	// only the center holds against a corner
+	g, _ = ParseGame("X2/3/3 O")
+	a = g.Analyze()
+	assert.Equal(t, a.Best(), Analysis{{Pos: Position{1, 1}}})
+	m, _ := a.Get(Position{1, 0})
+	assert.Equal(t, m.String(), "loss in 3")
+}
+
+func TestAnalyzeBoardCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a, err := AnalyzeBoard(ctx, NewBoard(5, 5, 4))
+	assert.Nil(t, a)
+	assert.Equal(t, err, context.Canceled)
+}
+
+func TestMoveAnalysisBetter(t *testing.T) {
+	win1 := MoveAnalysis{Value: ValueWin, Plies: 1}
+	win3 := MoveAnalysis{Value: ValueWin, Plies: 3}
+	draw := MoveAnalysis{}
+	loss2 := MoveAnalysis{Value: ValueLoss, Plies: 2}
+	loss4 := MoveAnalysis{Value: ValueLoss, Plies: 4}
+	ordered := []MoveAnalysis{win1, win3, draw, loss4, loss2}
+	for i := 1; i < len(ordered); i++ {
+		assert.True(t, ordered[i-1].Better(ordered[i]))
+		assert.False(t, ordered[i].Better(ordered[i-1]))
+	}
+}
@@ -0,0 +1,264 @@
+package ttt
+
+import (
+	"errors"
+	"math/bits"
+	"sync"
+)
+
+// Bitboards have a bit per cell in a uint64
+const MaxBitboardCells = 64
+
+var errBitboardSize = errors.New("Board does not fit in a bitboard")
+
+// Lines of K cells of a board size, and the lines through each cell
+type winMasks struct {
+	all    []uint64
+	byCell [][]uint64
+}
+
+type winMasksKey struct {
+	width, height, k int
+}
+
+var (
+	winMasksCache = make(map[winMasksKey]*winMasks)
+	winMasksMux   sync.Mutex
+)
+
+// The win masks of a board size, worked out once
+func winMasksFor(width, height, k int) *winMasks {
+	key := winMasksKey{width, height, k}
+	winMasksMux.Lock()
+	defer winMasksMux.Unlock()
+	if w := winMasksCache[key]; w != nil {
+		return w
+	}
+	w := &winMasks{byCell: make([][]uint64, width*height)}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for _, d := range [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}} {
+				endX, endY := x+(k-1)*d[0], y+(k-1)*d[1]
+				if endX >= width || endY < 0 || endY >= height {
+					continue
+				}
+				var mask uint64
+				for i := 0; i < k; i++ {
+					mask |= 1 << uint((y+i*d[1])*width+x+i*d[0])
+				}
+				w.all = append(w.all, mask)
+				for m := mask; m != 0; m &= m - 1 {
+					cell := bits.TrailingZeros64(m)
+					w.byCell[cell] = append(w.byCell[cell], mask)
+				}
+			}
+		}
+	}
+	winMasksCache[key] = w
+	return w
+}
+
+// An m,n,k-board with a bitmask per mark, bit y*Width+x standing for
+// the cell at (x, y). It plays like Board, only faster, and holds up
+// to 64 cells.
+type Bitboard struct {
+	Width  int
+	Height int
+	K      int
+	ToMove Mark
+	Moves  int
+	// Cells of X and of O
+	x, o   uint64
+	full   uint64
+	winner Mark
+	masks  *winMasks
+}
+
+// An empty bitboard with X to move
+func NewBitboard(width, height, k int) (*Bitboard, error) {
+	if width < 1 || height < 1 || width*height > MaxBitboardCells ||
+		k < 1 || k > width && k > height {
+		return nil, errBitboardSize
+	}
+	return &Bitboard{
+		Width:  width,
+		Height: height,
+		K:      k,
+		ToMove: MarkX,
+		full:   ^uint64(0) >> uint(MaxBitboardCells-width*height),
+		masks:  winMasksFor(width, height, k),
+	}, nil
+}
+
+// The bitboard of a standard grid
+func BitboardFromGrid(g *Grid, toMove Mark) *Bitboard {
+	b, _ := NewBitboard(Size, Size, Size)
+	for x, l := range g {
+		for y, m := range l {
+			bit := uint64(1) << uint(y*Size+x)
+			switch m {
+			case MarkX:
+				b.x |= bit
+			case MarkO:
+				b.o |= bit
+			}
+			if m != MarkEmpty {
+				b.Moves++
+			}
+		}
+	}
+	b.ToMove = toMove
+	for _, mask := range b.masks.all {
+		if b.x&mask == mask {
+			b.winner = MarkX
+		} else if b.o&mask == mask {
+			b.winner = MarkO
+		}
+	}
+	return b
+}
+
+// The bitboard of a board, if it fits in one
+func BitboardFromBoard(b *Board) (*Bitboard, error) {
+	bb, err := NewBitboard(b.Width, b.Height, b.K)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range b.cells {
+		switch m {
+		case MarkX:
+			bb.x |= 1 << uint(i)
+		case MarkO:
+			bb.o |= 1 << uint(i)
+		}
+	}
+	bb.ToMove = b.ToMove
+	bb.Moves = b.Moves
+	bb.winner = b.winner
+	return bb, nil
+}
+
+// The grid of a standard sized bitboard
+func (b *Bitboard) Grid() (Grid, error) {
+	var g Grid
+	if b.Width != Size || b.Height != Size {
+		return g, errBitboardSize
+	}
+	for x := 0; x < Size; x++ {
+		for y := 0; y < Size; y++ {
+			g[x][y] = b.Get(Position{x, y})
+		}
+	}
+	return g, nil
+}
+
+func (b *Bitboard) Clone() *Bitboard {
+	nb := *b
+	return &nb
+}
+
+func (b *Bitboard) Contains(p Position) bool {
+	return p.X >= 0 && p.X < b.Width && p.Y >= 0 && p.Y < b.Height
+}
+
+func (b *Bitboard) Get(p Position) Mark {
+	bit := uint64(1) << uint(p.Y*b.Width+p.X)
+	switch {
+	case b.x&bit != 0:
+		return MarkX
+	case b.o&bit != 0:
+		return MarkO
+	}
+	return MarkEmpty
+}
+
+// The mark with K in a row, if any
+func (b *Bitboard) Winner() Mark {
+	return b.winner
+}
+
+func (b *Bitboard) IsFull() bool {
+	return b.x|b.o == b.full
+}
+
+func (b *Bitboard) IsOver() bool {
+	return b.winner != MarkEmpty || b.IsFull()
+}
+
+// Free cells, row by row
+func (b *Bitboard) LegalMoves() []Position {
+	moves := []Position{}
+	if b.winner != MarkEmpty {
+		return moves
+	}
+	for free := b.full &^ (b.x | b.o); free != 0; free &= free - 1 {
+		i := bits.TrailingZeros64(free)
+		moves = append(moves, Position{i % b.Width, i / b.Width})
+	}
+	return moves
+}
+
+// Mark a cell for the player to move and pass the turn
+func (b *Bitboard) Play(p Position) error {
+	if b.IsOver() {
+		return errBoardOver
+	}
+	if !b.Contains(p) {
+		return errBadMove
+	}
+	cell := p.Y*b.Width + p.X
+	bit := uint64(1) << uint(cell)
+	if (b.x|b.o)&bit != 0 {
+		return errBadMove
+	}
+	own := &b.x
+	if b.ToMove == MarkO {
+		own = &b.o
+	}
+	*own |= bit
+	b.Moves++
+	for _, mask := range b.masks.byCell[cell] {
+		if *own&mask == mask {
+			b.winner = b.ToMove
+			break
+		}
+	}
+	b.ToMove = b.ToMove.Other()
+	return nil
+}
+
+// Take back the last move, played at p
+func (b *Bitboard) Undo(p Position) {
+	bit := uint64(1) << uint(p.Y*b.Width+p.X)
+	b.x &^= bit
+	b.o &^= bit
+	b.Moves--
+	b.winner = MarkEmpty
+	b.ToMove = b.ToMove.Other()
+}
+
+func (b *Bitboard) toMove() Mark {
+	return b.ToMove
+}
+
+func (b *Bitboard) size() (width, height int) {
+	return b.Width, b.Height
+}
+
+func (b *Bitboard) clone() playable {
+	return b.Clone()
+}
+
+// The key of a standard bitboard in the transposition table, false for
+// other bitboards
+func (b *Bitboard) canonicalKey() (positionKey, bool) {
+	if b.K != Size {
+		return positionKey{}, false
+	}
+	g, err := b.Grid()
+	if err != nil {
+		return positionKey{}, false
+	}
+	canonical, _ := g.Canonical()
+	return positionKey{canonical, b.ToMove}, true
+}
@@ -0,0 +1,208 @@
+package ttt
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBitboard(t *testing.T) {
+	_, err := NewBitboard(8, 8, 5)
+	assert.Nil(t, err)
+	_, err = NewBitboard(9, 8, 5)
+	assert.Equal(t, err, errBitboardSize)
+	_, err = NewBitboard(3, 3, 4)
+	assert.Equal(t, err, errBitboardSize)
+
+	// 3 rows, 3 columns and 2 diagonals
+	b, _ := NewBitboard(3, 3, 3)
+	assert.Equal(t, len(b.masks.all), 8)
+	assert.Equal(t, len(b.masks.byCell[4]), 4)
+	assert.Equal(t, len(b.masks.byCell[1]), 2)
+}
+
+func TestBitboardPlay(t *testing.T) {
+	b, _ := NewBitboard(4, 3, 3)
+	assert.Equal(t, len(b.LegalMoves()), 12)
+	assert.Nil(t, b.Play(Position{3, 2}))
+	assert.Equal(t, b.Get(Position{3, 2}), MarkX)
+	assert.Equal(t, b.ToMove, MarkO)
+	assert.Equal(t, b.Play(Position{3, 2}), errBadMove)
+	assert.Equal(t, b.Play(Position{4, 0}), errBadMove)
+	// X wins on the anti-diagonal of the right part of the board
+	for _, p := range []Position{{0, 0}, {2, 1}, {0, 1}, {1, 0}} {
+		assert.Nil(t, b.Play(p))
+	}
+	assert.Equal(t, b.Winner(), MarkX)
+	assert.Equal(t, b.Play(Position{0, 2}), errBoardOver)
+	b.Undo(Position{1, 0})
+	assert.Equal(t, b.Winner(), MarkEmpty)
+	assert.Equal(t, b.ToMove, MarkX)
+	assert.Equal(t, b.Moves, 4)
+}
+
+// Play random games on a Board and a Bitboard side by side
+func TestBitboardMatchesBoard(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, size := range [][3]int{{3, 3, 3}, {7, 7, 5}, {8, 8, 4}, {6, 4, 3}} {
+		for game := 0; game < 20; game++ {
+			board := NewBoard(size[0], size[1], size[2])
+			bb, err := NewBitboard(size[0], size[1], size[2])
+			assert.Nil(t, err)
+			played := []Position{}
+			for !board.IsOver() {
+				moves := board.LegalMoves()
+				assert.Equal(t, bb.LegalMoves(), moves)
+				p := moves[r.Intn(len(moves))]
+				board.Play(p)
+				bb.Play(p)
+				played = append(played, p)
+				assert.Equal(t, bb.Winner(), board.Winner())
+				assert.Equal(t, bb.IsFull(), board.IsFull())
+			}
+			assert.True(t, bb.IsOver())
+			for i := len(played) - 1; i >= 0; i-- {
+				bb.Undo(played[i])
+			}
+			empty, _ := NewBitboard(size[0], size[1], size[2])
+			assert.Equal(t, bb, empty)
+		}
+	}
+}
+
+func TestBitboardFromBoard(t *testing.T) {
+	board := NewBoard(5, 4, 3)
+	bb, _ := NewBitboard(5, 4, 3)
+	for _, p := range []Position{{0, 0}, {4, 3}, {1, 1}, {2, 0}, {2, 2}} {
+		board.Play(p)
+		bb.Play(p)
+		from, err := BitboardFromBoard(board)
+		assert.Nil(t, err)
+		assert.Equal(t, from, bb)
+	}
+	_, err := BitboardFromBoard(NewBoard(9, 9, 5))
+	assert.Equal(t, err, errBitboardSize)
+
+	// boards which do not fit are searched as they are
+	_, ok := playableOf(NewBoard(9, 9, 5)).(*Board)
+	assert.True(t, ok)
+	_, ok = playableOf(NewBoard(3, 3, 3)).(*Bitboard)
+	assert.True(t, ok)
+}
+
+func TestBitboardSearch(t *testing.T) {
+	board := NewBoard(4, 3, 3)
+	bb, _ := NewBitboard(4, 3, 3)
+	assert.Equal(t, search(context.Background(), bb, 1),
+		search(context.Background(), board, 1))
+}
+
+func TestBitboardGrid(t *testing.T) {
+	g, _ := ParseGame("X1O/1X1/O1X O")
+	b := BitboardFromGrid(&g.Grd, MarkO)
+	assert.Equal(t, b.Winner(), MarkX)
+	assert.Equal(t, b.Moves, 5)
+	assert.Equal(t, b.ToMove, MarkO)
+	grid, err := b.Grid()
+	assert.Nil(t, err)
+	assert.Equal(t, grid, g.Grd)
+
+	big, _ := NewBitboard(4, 4, 3)
+	_, err = big.Grid()
+	assert.Equal(t, err, errBitboardSize)
+}
+
+// Benchmarks of the same work done with Grid or Board and with a
+// Bitboard
+
+func BenchmarkGridHasSameMarksInRows(b *testing.B) {
+	g, _ := ParseGame("X1O/1X1/O2 X")
+	p := Position{2, 2}
+	g.Grd.Set(p, MarkX)
+	for i := 0; i < b.N; i++ {
+		g.Grd.HasSameMarksInRows(p, MarkX)
+	}
+}
+
+func BenchmarkBitboardPlayUndo(b *testing.B) {
+	g, _ := ParseGame("X1O/1X1/O2 X")
+	bb := BitboardFromGrid(&g.Grd, MarkX)
+	p := Position{2, 2}
+	for i := 0; i < b.N; i++ {
+		bb.Play(p)
+		bb.Undo(p)
+	}
+}
+
+func BenchmarkBoardPlayout(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i++ {
+		board := NewBoard(8, 8, 5)
+		for !board.IsOver() {
+			moves := board.LegalMoves()
+			board.Play(moves[r.Intn(len(moves))])
+		}
+	}
+}
+
+func BenchmarkBitboardPlayout(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i++ {
+		bb, _ := NewBitboard(8, 8, 5)
+		for !bb.IsOver() {
+			moves := bb.LegalMoves()
+			bb.Play(moves[r.Intn(len(moves))])
+		}
+	}
+}
+
+func BenchmarkGameGetBestMove(b *testing.B) {
+	g, _ := ParseGame("X2/1O1/3 X")
+	for i := 0; i < b.N; i++ {
+		g.GetBestMove(MarkX)
+	}
+}
+
+// Plain negamax to the end of the game
+func solveBitboard(bb *Bitboard) int {
+	if bb.Winner() != MarkEmpty {
+		return -1
+	} else if bb.IsFull() {
+		return 0
+	}
+	best := -1
+	for _, p := range bb.LegalMoves() {
+		bb.Play(p)
+		if v := -solveBitboard(bb); v > best {
+			best = v
+		}
+		bb.Undo(p)
+	}
+	return best
+}
+
+func BenchmarkBitboardSolve(b *testing.B) {
+	g, _ := ParseGame("X2/1O1/3 X")
+	bb := BitboardFromGrid(&g.Grd, MarkX)
+	for i := 0; i < b.N; i++ {
+		solveBitboard(bb)
+	}
+}
+
+// The same search on a Board and on a Bitboard
+func benchmarkSearch(b *testing.B, board playable) {
+	for i := 0; i < b.N; i++ {
+		search(context.Background(), board, 1)
+	}
+}
+
+func BenchmarkSearchBoard(b *testing.B) {
+	benchmarkSearch(b, NewBoard(4, 3, 3))
+}
+
+func BenchmarkSearchBitboard(b *testing.B) {
+	bb, _ := NewBitboard(4, 3, 3)
+	benchmarkSearch(b, bb)
+}
@@ -0,0 +1,210 @@
+package ttt
+
+import (
+	"errors"
+)
+
+var (
+	errBoardOver = errors.New("Game is over")
+	errBadMove   = errors.New("Cell is outside or taken")
+)
+
+// An m,n,k-game: marks are placed on a Width by Height board and K in
+// a row wins. Grid and Game are the 3,3,3 case; engines which need
+// bigger boards use this.
+type Board struct {
+	Width  int
+	Height int
+	K      int
+	ToMove Mark
+	// Number of moves played
+	Moves  int
+	cells  []Mark
+	winner Mark
+	// Kept up to date on every move once a key table is in use
+	zobrist *ZobristTable
+	hash    uint64
+}
+
+// An empty board with X to move
+func NewBoard(width, height, k int) *Board {
+	return &Board{
+		Width:  width,
+		Height: height,
+		K:      k,
+		ToMove: MarkX,
+		cells:  make([]Mark, width*height),
+	}
+}
+
+// The board of a standard game
+func BoardFromGame(g Game) *Board {
+	b := NewBoard(Size, Size, Size)
+	for x, l := range g.Grd {
+		for y, m := range l {
+			b.cells[y*b.Width+x] = m
+			if m != MarkEmpty {
+				b.Moves++
+			}
+		}
+	}
+	b.ToMove = g.CurrentPlayer
+	switch {
+	case g.Grd.HasWon(MarkX):
+		b.winner = MarkX
+	case g.Grd.HasWon(MarkO):
+		b.winner = MarkO
+	}
+	return b
+}
+
+func (b *Board) Clone() *Board {
+	nb := *b
+	nb.cells = append([]Mark{}, b.cells...)
+	return &nb
+}
+
+func (b *Board) Contains(p Position) bool {
+	return p.X >= 0 && p.X < b.Width && p.Y >= 0 && p.Y < b.Height
+}
+
+func (b *Board) Get(p Position) Mark {
+	return b.cells[p.Y*b.Width+p.X]
+}
+
+// The mark with K in a row, if any
+func (b *Board) Winner() Mark {
+	return b.winner
+}
+
+func (b *Board) IsFull() bool {
+	return b.Moves == len(b.cells)
+}
+
+func (b *Board) IsOver() bool {
+	return b.winner != MarkEmpty || b.IsFull()
+}
+
+// Free cells, row by row
+func (b *Board) LegalMoves() []Position {
+	moves := []Position{}
+	if b.winner != MarkEmpty {
+		return moves
+	}
+	for i, m := range b.cells {
+		if m == MarkEmpty {
+			moves = append(moves, Position{i % b.Width, i / b.Width})
+		}
+	}
+	return moves
+}
+
+// Count the marks like the one at p going from p in a direction
+func (b *Board) run(p Position, dx, dy int) int {
+	m := b.Get(p)
+	n := 0
+	q := Position{p.X + dx, p.Y + dy}
+	for b.Contains(q) && b.Get(q) == m {
+		n++
+		q = Position{q.X + dx, q.Y + dy}
+	}
+	return n
+}
+
+// Mark a cell for the player to move and pass the turn
+func (b *Board) Play(p Position) error {
+	if b.IsOver() {
+		return errBoardOver
+	}
+	if !b.Contains(p) || b.Get(p) != MarkEmpty {
+		return errBadMove
+	}
+	b.cells[p.Y*b.Width+p.X] = b.ToMove
+	b.Moves++
+	if b.zobrist != nil {
+		b.hash ^= b.zobrist.Move(p, b.ToMove)
+	}
+	for _, d := range [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}} {
+		if 1+b.run(p, d[0], d[1])+b.run(p, -d[0], -d[1]) >= b.K {
+			b.winner = b.ToMove
+			break
+		}
+	}
+	b.ToMove = b.ToMove.Other()
+	return nil
+}
+
+// Take back the last move, played at p
+func (b *Board) Undo(p Position) {
+	if b.zobrist != nil {
+		b.hash ^= b.zobrist.Move(p, b.Get(p))
+	}
+	b.cells[p.Y*b.Width+p.X] = MarkEmpty
+	b.Moves--
+	b.winner = MarkEmpty
+	b.ToMove = b.ToMove.Other()
+}
+
+// Hash the board with a key table from now on
+func (b *Board) UseZobrist(z *ZobristTable) error {
+	if z.Width != b.Width || z.Height != b.Height {
+		return errZobristSize
+	}
+	b.zobrist = z
+	b.hash = z.HashBoard(b)
+	return nil
+}
+
+// The Zobrist hash of the board, 0 until a key table is in use
+func (b *Board) Hash() uint64 {
+	return b.hash
+}
+
+// What the engines search on: a Board, or a Bitboard for the boards
+// which fit in one
+type playable interface {
+	LegalMoves() []Position
+	Play(p Position) error
+	Undo(p Position)
+	Winner() Mark
+	IsFull() bool
+	IsOver() bool
+	toMove() Mark
+	size() (width, height int)
+	clone() playable
+	canonicalKey() (positionKey, bool)
+}
+
+// The fastest playable form of b, which is left alone
+func playableOf(b *Board) playable {
+	if bb, err := BitboardFromBoard(b); err == nil {
+		return bb
+	}
+	return b.Clone()
+}
+
+// The key of a standard board in the transposition table, false for
+// other boards
+func (b *Board) canonicalKey() (positionKey, bool) {
+	if b.Width != Size || b.Height != Size || b.K != Size {
+		return positionKey{}, false
+	}
+	var g Grid
+	for i, m := range b.cells {
+		g[i%Size][i/Size] = m
+	}
+	canonical, _ := g.Canonical()
+	return positionKey{canonical, b.ToMove}, true
+}
+
+func (b *Board) toMove() Mark {
+	return b.ToMove
+}
+
+func (b *Board) size() (width, height int) {
+	return b.Width, b.Height
+}
+
+func (b *Board) clone() playable {
+	return b.Clone()
+}
@@ -0,0 +1,83 @@
+package ttt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoardPlay(t *testing.T) {
+	b := NewBoard(4, 3, 3)
+	assert.Equal(t, len(b.LegalMoves()), 12)
+	assert.Nil(t, b.Play(Position{3, 2}))
+	assert.Equal(t, b.Get(Position{3, 2}), MarkX)
+	assert.Equal(t, b.ToMove, MarkO)
+	assert.Equal(t, b.Play(Position{3, 2}), errBadMove)
+	assert.Equal(t, b.Play(Position{4, 0}), errBadMove)
+	assert.Equal(t, b.Moves, 1)
+	assert.Equal(t, len(b.LegalMoves()), 11)
+}
+
+func TestBoardWinner(t *testing.T) {
+	// X wins on the anti-diagonal of the right part of the board
+	b := NewBoard(4, 3, 3)
+	moves := []Position{{3, 0}, {0, 0}, {2, 1}, {0, 1}, {1, 2}}
+	for _, p := range moves {
+		assert.Equal(t, b.Winner(), MarkEmpty)
+		assert.Nil(t, b.Play(p))
+	}
+	assert.Equal(t, b.Winner(), MarkX)
+	assert.True(t, b.IsOver())
+	assert.Equal(t, len(b.LegalMoves()), 0)
+	assert.Equal(t, b.Play(Position{3, 2}), errBoardOver)
+
+	// a long row is needed on a bigger board
+	b = NewBoard(7, 7, 5)
+	for x := 0; x < 4; x++ {
+		b.Play(Position{x, 3})
+		b.Play(Position{x, 0})
+	}
+	assert.Equal(t, b.Winner(), MarkEmpty)
+	b.Play(Position{4, 3})
+	assert.Equal(t, b.Winner(), MarkX)
+}
+
+func TestBoardFull(t *testing.T) {
+	g, _ := ParseGame("XOX/XOO/OXX O")
+	b := BoardFromGame(g)
+	assert.True(t, b.IsFull())
+	assert.True(t, b.IsOver())
+	assert.Equal(t, b.Winner(), MarkEmpty)
+}
+
+func TestBoardFromGame(t *testing.T) {
+	g, _ := ParseGame("X1O/1X1/3 O")
+	b := BoardFromGame(g)
+	assert.Equal(t, b.Moves, 3)
+	assert.Equal(t, b.ToMove, MarkO)
+	assert.Equal(t, b.Get(Position{2, 0}), MarkO)
+	assert.Equal(t, b.Get(Position{1, 1}), MarkX)
+
+	g, _ = ParseGame("XXX/OO1/3 O")
+	assert.Equal(t, BoardFromGame(g).Winner(), MarkX)
+}
+
+func TestBoardClone(t *testing.T) {
+	b := NewBoard(3, 3, 3)
+	nb := b.Clone()
+	nb.Play(Position{1, 1})
+	assert.Equal(t, b.Get(Position{1, 1}), MarkEmpty)
+	assert.Equal(t, b.Moves, 0)
+}
+
+func TestBoardUndo(t *testing.T) {
+	b := NewBoard(3, 3, 3)
+	for _, p := range []Position{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		b.Play(p)
+	}
+	before := b.Clone()
+	b.Play(Position{0, 2})
+	assert.Equal(t, b.Winner(), MarkX)
+	b.Undo(Position{0, 2})
+	assert.Equal(t, b, before)
+}
@@ -0,0 +1,202 @@
+package ttt
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Something which picks moves. Bots play with an engine, and new ones
+// can be plugged in with RegisterEngine.
+type Engine interface {
+	// Pick a move for the current player of a game which is not over,
+	// taking about budget at most. The score is from the point of view
+	// of the current player and only means something if scored is
+	// true.
+	Move(g Game, budget time.Duration) (r GameResult, scored bool)
+}
+
+const (
+	EngineMinimax = "minimax"
+	EngineRandom  = "random"
+	EngineRules   = "rules"
+)
+
+var errUnknownEngine = errors.New("No such engine")
+
+var (
+	engines    = make(map[string]func() Engine)
+	enginesMux sync.Mutex
+)
+
+func init() {
+	RegisterEngine(EngineMinimax, func() Engine { return &MinimaxEngine{} })
+	RegisterEngine(EngineRandom, func() Engine { return &RandomEngine{} })
+	RegisterEngine(EngineRules, func() Engine { return &RuleEngine{} })
+}
+
+// Make an engine available by name. Registering a name again replaces
+// the engine.
+func RegisterEngine(name string, newEngine func() Engine) {
+	enginesMux.Lock()
+	defer enginesMux.Unlock()
+	engines[name] = newEngine
+}
+
+// A new instance of a registered engine
+func NewEngine(name string) (Engine, error) {
+	enginesMux.Lock()
+	defer enginesMux.Unlock()
+	newEngine := engines[name]
+	if newEngine == nil {
+		return nil, errUnknownEngine
+	}
+	return newEngine(), nil
+}
+
+// Names of the registered engines, sorted
+func EngineNames() []string {
+	enginesMux.Lock()
+	defer enginesMux.Unlock()
+	names := []string{}
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Search the game tree with minimax, Depth moves ahead or to the end
+// of the game when Depth is 0. The search goes one move deeper at a
+// time, and stops early with the deepest result once the budget is
+// spent.
+type MinimaxEngine struct {
+	Depth int
+}
+
+func (e *MinimaxEngine) Move(g Game, budget time.Duration) (GameResult, bool) {
+	deadline := time.Now().Add(budget)
+	end := len(g.Grd.GetAvailableCells())
+	limit := end
+	if e.Depth > 0 && e.Depth < limit {
+		limit = e.Depth
+	}
+	r := GameResult{}
+	depth := 0
+	for depth < limit {
+		depth++
+		r = g.GetBestMoveWithin(g.CurrentPlayer, depth)
+		// a won or lost game needs no deeper look
+		if r.Score != 0 || !time.Now().Before(deadline) {
+			break
+		}
+	}
+	return r, r.Score != 0 || depth == end
+}
+
+// Play any free cell
+type RandomEngine struct{}
+
+func (e *RandomEngine) Move(g Game, budget time.Duration) (GameResult, bool) {
+	cells := g.Grd.GetAvailableCells()
+	if len(cells) == 0 {
+		return GameResult{}, false
+	}
+	return GameResult{Pos: cells[RandInt(len(cells))]}, false
+}
+
+// Follow the classic rules: win, block, fork, block a fork, then take
+// the center, the corner opposite to the other player, any corner and
+// any side
+type RuleEngine struct{}
+
+// Free cells which would complete a line of a mark
+func (g *Grid) winningCells(m Mark) []Position {
+	cells := []Position{}
+	seen := make(map[Position]bool)
+	for _, l := range winLines() {
+		free := []Position{}
+		marks := 0
+		for _, p := range l {
+			switch g.Get(p) {
+			case m:
+				marks++
+			case MarkEmpty:
+				free = append(free, p)
+			}
+		}
+		if marks == Size-1 && len(free) == 1 && !seen[free[0]] {
+			seen[free[0]] = true
+			cells = append(cells, free[0])
+		}
+	}
+	return cells
+}
+
+// Free cells which would leave a mark two ways to win
+func (g *Grid) forkCells(m Mark) []Position {
+	cells := []Position{}
+	for _, p := range g.GetAvailableCells() {
+		ng := *g
+		ng.Set(p, m)
+		if len(ng.winningCells(m)) >= 2 {
+			cells = append(cells, p)
+		}
+	}
+	return cells
+}
+
+func containsPosition(ps []Position, p Position) bool {
+	for _, q := range ps {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *RuleEngine) Move(g Game, budget time.Duration) (GameResult, bool) {
+	me, other := g.CurrentPlayer, g.CurrentPlayer.Other()
+	grid := &g.Grd
+	if cells := grid.winningCells(me); len(cells) > 0 {
+		return GameResult{Score, cells[0]}, true
+	}
+	if cells := grid.winningCells(other); len(cells) > 0 {
+		return GameResult{Pos: cells[0]}, false
+	}
+	if cells := grid.forkCells(me); len(cells) > 0 {
+		return GameResult{Score, cells[0]}, true
+	}
+	if forks := grid.forkCells(other); len(forks) == 1 {
+		return GameResult{Pos: forks[0]}, false
+	} else if len(forks) > 1 {
+		// threaten a win somewhere the other player can block
+		// without forking
+		for _, p := range grid.GetAvailableCells() {
+			ng := *grid
+			ng.Set(p, me)
+			threats := ng.winningCells(me)
+			if len(threats) == 1 && !containsPosition(forks, threats[0]) {
+				return GameResult{Pos: p}, false
+			}
+		}
+	}
+	center := GetCenter()
+	if grid.Get(center) == MarkEmpty {
+		return GameResult{Pos: center}, false
+	}
+	for _, c := range Corners {
+		opposite := Position{Size - 1 - c.X, Size - 1 - c.Y}
+		if grid.Get(c) == other && grid.Get(opposite) == MarkEmpty {
+			return GameResult{Pos: opposite}, false
+		}
+	}
+	for _, c := range Corners {
+		if grid.Get(c) == MarkEmpty {
+			return GameResult{Pos: c}, false
+		}
+	}
+	cells := grid.GetAvailableCells()
+	return GameResult{Pos: cells[0]}, false
+}
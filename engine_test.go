@@ -0,0 +1,140 @@
+package ttt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Play an engine as a mark against every possible sequence of moves
+// of the other mark and count the games it loses
+func losses(e Engine, g Game, engineMark Mark) int {
+	if g.Grd.HasWon(engineMark.Other()) {
+		return 1
+	}
+	if g.Grd.HasWon(engineMark) || g.Grd.IsFull() {
+		return 0
+	}
+	if g.CurrentPlayer == engineMark {
+		r, _ := e.Move(g, time.Second)
+		if g.Grd.Get(r.Pos) != MarkEmpty {
+			panic("engine played a taken cell")
+		}
+		g.Grd.Set(r.Pos, engineMark)
+		g.SwitchTurn()
+		return losses(e, g, engineMark)
+	}
+	n := 0
+	for _, p := range g.Grd.GetAvailableCells() {
+		ng := g
+		ng.Grd.Set(p, ng.CurrentPlayer)
+		ng.SwitchTurn()
+		n += losses(e, ng, engineMark)
+	}
+	return n
+}
+
+func TestEnginesNeverLose(t *testing.T) {
+	for _, name := range []string{EngineDeepening, EngineMinimax, EngineRules} {
+		e, err := NewEngine(name)
+		assert.Nil(t, err)
+		for _, m := range []Mark{MarkX, MarkO} {
+			g := Game{CurrentPlayer: MarkX, NextPlayer: MarkO}
+			assert.Equal(t, losses(e, g, m), 0, name+" as "+m.String())
+		}
+	}
+}
+
+func TestRandomEngine(t *testing.T) {
+	g, _ := ParseGame("XOX/OXO/2O X")
+	r, scored := (&RandomEngine{}).Move(g, time.Second)
+	assert.False(t, scored)
+	assert.True(t, r.Pos == Position{0, 2} || r.Pos == Position{1, 2})
+
+	g, _ = ParseGame("XOX/OXO/OXO X")
+	r, scored = (&RandomEngine{}).Move(g, time.Second)
+	assert.False(t, scored)
+	assert.Equal(t, r, GameResult{})
+}
+
+func TestMinimaxEngine(t *testing.T) {
+	g, _ := ParseGame("X2/1O1/2X O")
+	r, scored := (&MinimaxEngine{}).Move(g, time.Second)
+	assert.True(t, scored)
+	assert.Equal(t, r.Score, 0)
+	r, scored = (&MinimaxEngine{Depth: 1}).Move(g, time.Second)
+	assert.False(t, scored)
+	assert.Equal(t, r, GameResult{0, Position{0, 1}})
+
+	// a spent budget leaves one move to look at, which misses the fork
+	r, scored = (&MinimaxEngine{}).Move(g, 0)
+	assert.False(t, scored)
+	assert.Equal(t, r, GameResult{0, Position{0, 1}})
+
+	// a win is sure however shallow the search
+	g, _ = ParseGame("XX1/OO1/X2 O")
+	r, scored = (&MinimaxEngine{}).Move(g, 0)
+	assert.True(t, scored)
+	assert.Equal(t, r, GameResult{Score, Position{2, 1}})
+}
+
+func TestRuleEngine(t *testing.T) {
+	moves := []struct {
+		position string
+		expected Position
+		scored   bool
+	}{
+		// win
+		{"XX1/OO1/X2 O", Position{2, 1}, true},
+		// block
+		{"X1O/1X1/3 O", Position{2, 2}, false},
+		// fork
+		{"XOX/3/O2 X", Position{2, 2}, true},
+		// block two forks by threatening a win
+		{"X2/1O1/2X O", Position{0, 1}, false},
+		// center, then corners
+		{"3/3/3 X", Position{1, 1}, false},
+		{"3/1X1/3 O", Position{0, 0}, false},
+		// the corner opposite to the other player
+		{"O2/1X1/3 X", Position{2, 2}, false},
+	}
+	e := &RuleEngine{}
+	for _, m := range moves {
+		g, err := ParseGame(m.position)
+		assert.Nil(t, err, m.position)
+		r, scored := e.Move(g, time.Second)
+		assert.Equal(t, r.Pos, m.expected, m.position)
+		assert.Equal(t, scored, m.scored, m.position)
+	}
+}
+
+func TestGridWinningCells(t *testing.T) {
+	g, _ := ParseGame("XX1/1O1/O2 X")
+	assert.Equal(t, g.Grd.winningCells(MarkX), []Position{{2, 0}})
+	assert.Equal(t, g.Grd.winningCells(MarkO), []Position{{2, 0}})
+	assert.Equal(t, len(g.Grd.forkCells(MarkX)), 0)
+}
+
+type fixedEngine struct{}
+
+func (e *fixedEngine) Move(g Game, budget time.Duration) (GameResult, bool) {
+	return GameResult{Pos: g.Grd.GetAvailableCells()[0]}, false
+}
+
+func TestRegisterEngine(t *testing.T) {
+	_, err := NewEngine("fixed")
+	assert.Equal(t, err, errUnknownEngine)
+
+	RegisterEngine("fixed", func() Engine { return &fixedEngine{} })
+	defer func() {
+		enginesMux.Lock()
+		delete(engines, "fixed")
+		enginesMux.Unlock()
+	}()
+	e, err := NewEngine("fixed")
+	assert.Nil(t, err)
+	assert.Equal(t, e, &fixedEngine{})
+	assert.Equal(t, EngineNames(), []string{EngineDeepening, "fixed", EngineMCTS,
+		EngineMinimax, EngineRandom, EngineRules})
+}
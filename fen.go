@@ -0,0 +1,184 @@
+package ttt
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A position is written on one line, much like a chess FEN: the rows
+// from the top separated by slashes, the mark to move, the board size
+// and the variant.
+//
+//	X1O/1X1/3 O 3x3 Standard
+//
+// Digits stand for runs of empty cells. The size and the variant may
+// be left out when parsing.
+
+var (
+	errBadPosition = errors.New("Bad position")
+	errMarkCount   = errors.New("Wrong number of marks")
+	errWrongToMove = errors.New("Wrong mark to move")
+	errTwoWinners  = errors.New("Both marks have won")
+	errPlayedOnWon = errors.New("Moves after the game was won")
+	errBoardSize   = errors.New("Unsupported board size")
+	errVariant     = errors.New("Unsupported variant")
+)
+
+// Lines of cells which win the game when they have the same mark
+func winLines() [][]Position {
+	lines := [][]Position{}
+	ld := []Position{}
+	rd := []Position{}
+	for i := 0; i < Size; i++ {
+		h := []Position{}
+		v := []Position{}
+		for j := 0; j < Size; j++ {
+			h = append(h, Position{j, i})
+			v = append(v, Position{i, j})
+		}
+		lines = append(lines, h, v)
+		ld = append(ld, Position{i, i})
+		rd = append(rd, Position{i, Size - 1 - i})
+	}
+	return append(lines, ld, rd)
+}
+
+// Whether a mark has a line of its own
+func (g *Grid) HasWon(m Mark) bool {
+	for _, l := range winLines() {
+		won := true
+		for _, p := range l {
+			if g.Get(p) != m {
+				won = false
+				break
+			}
+		}
+		if won {
+			return true
+		}
+	}
+	return false
+}
+
+// Count the marks of each player
+func (g *Grid) Count() (int, int) {
+	xs, os := 0, 0
+	for _, l := range g {
+		for _, m := range l {
+			switch m {
+			case MarkX:
+				xs++
+			case MarkO:
+				os++
+			}
+		}
+	}
+	return xs, os
+}
+
+// Check that the grid can be reached in a game started by X with the
+// given mark to move
+func (g *Grid) Validate(toMove Mark) error {
+	xs, os := g.Count()
+	var expected Mark
+	switch xs - os {
+	case 0:
+		expected = MarkX
+	case 1:
+		expected = MarkO
+	default:
+		return errMarkCount
+	}
+	if toMove != expected {
+		return errWrongToMove
+	}
+	xWon, oWon := g.HasWon(MarkX), g.HasWon(MarkO)
+	switch {
+	case xWon && oWon:
+		return errTwoWinners
+	case xWon && toMove != MarkO, oWon && toMove != MarkX:
+		return errPlayedOnWon
+	}
+	return nil
+}
+
+func (g Game) String() string {
+	rows := []string{}
+	for y := 0; y < Size; y++ {
+		row := ""
+		empty := 0
+		for x := 0; x < Size; x++ {
+			m := g.Grd[x][y]
+			if m == MarkEmpty {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				row += strconv.Itoa(empty)
+				empty = 0
+			}
+			row += m.String()
+		}
+		if empty > 0 {
+			row += strconv.Itoa(empty)
+		}
+		rows = append(rows, row)
+	}
+	size := strconv.Itoa(Size) + "x" + strconv.Itoa(Size)
+	return strings.Join([]string{strings.Join(rows, "/"),
+		g.CurrentPlayer.String(), size, VariantStandard}, " ")
+}
+
+// Parse a position and check that it can be reached
+func ParseGame(s string) (Game, error) {
+	g := Game{}
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 4 {
+		return g, errBadPosition
+	}
+	if len(fields) > 2 && fields[2] != strconv.Itoa(Size)+"x"+strconv.Itoa(Size) {
+		return g, errBoardSize
+	}
+	if len(fields) > 3 && fields[3] != VariantStandard {
+		return g, errVariant
+	}
+	switch fields[1] {
+	case "X":
+		g.CurrentPlayer = MarkX
+	case "O":
+		g.CurrentPlayer = MarkO
+	default:
+		return g, errBadPosition
+	}
+	g.NextPlayer = g.CurrentPlayer.Other()
+
+	rows := strings.Split(fields[0], "/")
+	if len(rows) != Size {
+		return g, errBoardSize
+	}
+	for y, row := range rows {
+		x := 0
+		for _, c := range row {
+			if x >= Size {
+				return g, errBoardSize
+			}
+			switch {
+			case c == 'X':
+				g.Grd[x][y] = MarkX
+				x++
+			case c == 'O':
+				g.Grd[x][y] = MarkO
+				x++
+			case c >= '1' && c <= '9':
+				x += int(c - '0')
+			default:
+				return g, errBadPosition
+			}
+		}
+		if x != Size {
+			return g, errBoardSize
+		}
+	}
+	return g, g.Grd.Validate(g.CurrentPlayer)
+}
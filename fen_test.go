@@ -0,0 +1,92 @@
+package ttt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGameString(t *testing.T) {
+	g := Game{CurrentPlayer: MarkX, NextPlayer: MarkO}
+	assert.Equal(t, g.String(), "3/3/3 X 3x3 Standard")
+	g.Grd = Grid{
+		{MarkX, MarkEmpty, MarkEmpty},
+		{MarkEmpty, MarkX, MarkEmpty},
+		{MarkO, MarkEmpty, MarkEmpty},
+	}
+	g.SwitchTurn()
+	assert.Equal(t, g.String(), "X1O/1X1/3 O 3x3 Standard")
+}
+
+func TestParseGame(t *testing.T) {
+	g, err := ParseGame("X1O/1X1/3 O 3x3 Standard")
+	assert.Nil(t, err)
+	assert.Equal(t, g, Game{
+		CurrentPlayer: MarkO,
+		NextPlayer:    MarkX,
+		Grd: Grid{
+			{MarkX, MarkEmpty, MarkEmpty},
+			{MarkEmpty, MarkX, MarkEmpty},
+			{MarkO, MarkEmpty, MarkEmpty},
+		},
+	})
+
+	// size and variant are optional
+	again, err := ParseGame("X1O/1X1/3 O")
+	assert.Nil(t, err)
+	assert.Equal(t, again, g)
+
+	positions := []string{
+		"3/3/3 X",
+		"XOX/XOO/OXX O",
+		"XXX/OO1/3 O",
+		"O1X/1X1/X1O O",
+		"OX1/OX1/O1X X",
+	}
+	for _, s := range positions {
+		g, err := ParseGame(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, g.String(), s+" 3x3 Standard")
+	}
+}
+
+func TestParseGameErrors(t *testing.T) {
+	bad := map[string]error{
+		"":                       errBadPosition,
+		"3/3/3":                  errBadPosition,
+		"3/3/3 x":                errBadPosition,
+		"3/3/3 X 3x3 Standard 1": errBadPosition,
+		"3/3/2Y X":               errBadPosition,
+		"3/3/3 X 4x4":            errBoardSize,
+		"3/3 X":                  errBoardSize,
+		"3/4/3 X":                errBoardSize,
+		"3/XXXO/3 X":             errBoardSize,
+		"3/2/3 X":                errBoardSize,
+		"3/3/3 X 3x3 Gomoku":     errVariant,
+		"XX1/3/3 O":              errMarkCount,
+		"O2/3/3 X":               errMarkCount,
+		"X2/3/3 X":               errWrongToMove,
+		"XXX/OOO/3 X":            errTwoWinners,
+		"XXX/OO1/O2 X":           errPlayedOnWon,
+		"OOO/XX1/X1X O":          errPlayedOnWon,
+	}
+	for s, expected := range bad {
+		_, err := ParseGame(s)
+		assert.Equal(t, err, expected, s)
+	}
+}
+
+func TestGridHasWon(t *testing.T) {
+	g, _ := ParseGame("X1O/OX1/2X O")
+	assert.True(t, g.Grd.HasWon(MarkX))
+	assert.False(t, g.Grd.HasWon(MarkO))
+	g, _ = ParseGame("1XO/XO1/O1X X")
+	assert.True(t, g.Grd.HasWon(MarkO))
+}
+
+func TestGridCount(t *testing.T) {
+	g, _ := ParseGame("X1O/1X1/3 O")
+	xs, os := g.Grd.Count()
+	assert.Equal(t, xs, 2)
+	assert.Equal(t, os, 1)
+}
@@ -0,0 +1,200 @@
+package ttt
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	EngineMCTS = "mcts"
+
+	// The usual exploration constant of UCT, the square root of 2
+	DefaultExploration = math.Sqrt2
+)
+
+func init() {
+	RegisterEngine(EngineMCTS, func() Engine {
+		return NewMCTSEngine(time.Now().UnixNano())
+	})
+}
+
+// A position in the search tree, reached by a move of mover
+type mctsNode struct {
+	move     Position
+	mover    Mark
+	parent   *mctsNode
+	children []*mctsNode
+	untried  []Position
+	visits   int
+	// Wins of mover counted as 1 and draws as 1/2
+	wins float64
+}
+
+func newMCTSNode(b playable, move Position, parent *mctsNode) *mctsNode {
+	return &mctsNode{
+		move:    move,
+		mover:   b.toMove().Other(),
+		parent:  parent,
+		untried: b.LegalMoves(),
+	}
+}
+
+// The child with the best upper confidence bound
+func (n *mctsNode) selectChild(c float64) *mctsNode {
+	var best *mctsNode
+	bestValue := math.Inf(-1)
+	logVisits := math.Log(float64(n.visits))
+	for _, child := range n.children {
+		v := child.wins/float64(child.visits) +
+			c*math.Sqrt(logVisits/float64(child.visits))
+		if v > bestValue {
+			best = child
+			bestValue = v
+		}
+	}
+	return best
+}
+
+// The most visited child
+func (n *mctsNode) mostVisited() *mctsNode {
+	var best *mctsNode
+	for _, child := range n.children {
+		if best == nil || child.visits > best.visits {
+			best = child
+		}
+	}
+	return best
+}
+
+// Monte Carlo tree search with UCT. The tree of the last search is
+// kept, and picked up again when the next position follows from the
+// move chosen then.
+type MCTSEngine struct {
+	// Weight of exploring moves tried less often
+	Exploration float64
+	// Playouts per move. When 0 the search runs until the budget is
+	// spent.
+	Playouts int
+
+	rand *rand.Rand
+	// Subtree of the last chosen move and the board after it
+	tree      *mctsNode
+	treeBoard *Board
+}
+
+// An engine with the default exploration and random numbers from seed
+func NewMCTSEngine(seed int64) *MCTSEngine {
+	return &MCTSEngine{
+		Exploration: DefaultExploration,
+		rand:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (e *MCTSEngine) Move(g Game, budget time.Duration) (GameResult, bool) {
+	p, _ := e.Search(BoardFromGame(g), budget)
+	return GameResult{Pos: p}, false
+}
+
+// Find the subtree for b in the kept tree: b must be the board after
+// the last chosen move and one more move
+func (e *MCTSEngine) reuse(b *Board) *mctsNode {
+	if e.tree == nil || e.treeBoard.Width != b.Width ||
+		e.treeBoard.Height != b.Height || e.treeBoard.K != b.K ||
+		b.Moves != e.treeBoard.Moves+1 {
+		return nil
+	}
+	for _, child := range e.tree.children {
+		nb := e.treeBoard.Clone()
+		nb.Play(child.move)
+		if equalCells(nb, b) {
+			child.parent = nil
+			return child
+		}
+	}
+	return nil
+}
+
+func equalCells(a, b *Board) bool {
+	for i := range a.cells {
+		if a.cells[i] != b.cells[i] {
+			return false
+		}
+	}
+	return a.ToMove == b.ToMove
+}
+
+// Search for the best move of the player to move on b. The rate of
+// wins of the move, counting draws as half, is returned with it.
+func (e *MCTSEngine) Search(b *Board, budget time.Duration) (Position, float64) {
+	if e.rand == nil {
+		e.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	// playouts go much faster on the bitboard of b
+	pb := playableOf(b)
+	root := e.reuse(b)
+	if root == nil {
+		root = newMCTSNode(pb, Position{}, nil)
+	}
+	deadline := time.Now().Add(budget)
+	for i := 0; ; i++ {
+		if e.Playouts > 0 && i >= e.Playouts {
+			break
+		}
+		// checking the clock every time would cost more than a playout,
+		// and there is always a move to give however short the budget
+		if e.Playouts == 0 && i > 0 && i%64 == 0 &&
+			!time.Now().Before(deadline) {
+			break
+		}
+		e.iterate(root, pb.clone())
+	}
+	best := root.mostVisited()
+	if best == nil {
+		return Position{}, 0
+	}
+	e.tree = best
+	e.treeBoard = b.Clone()
+	e.treeBoard.Play(best.move)
+	return best.move, best.wins / float64(best.visits)
+}
+
+// Select, expand, play out and back up once
+func (e *MCTSEngine) iterate(root *mctsNode, b playable) {
+	n := root
+	for len(n.untried) == 0 && len(n.children) > 0 {
+		n = n.selectChild(e.Exploration)
+		b.Play(n.move)
+	}
+	if len(n.untried) > 0 {
+		i := e.rand.Intn(len(n.untried))
+		move := n.untried[i]
+		n.untried[i] = n.untried[len(n.untried)-1]
+		n.untried = n.untried[:len(n.untried)-1]
+		b.Play(move)
+		child := newMCTSNode(b, move, n)
+		n.children = append(n.children, child)
+		n = child
+	}
+	winner := e.playout(b)
+	for ; n != nil; n = n.parent {
+		n.visits++
+		if winner == n.mover {
+			n.wins++
+		} else if winner == MarkEmpty {
+			n.wins += 0.5
+		}
+	}
+}
+
+// Play random moves to the end and return the winner
+func (e *MCTSEngine) playout(b playable) Mark {
+	moves := b.LegalMoves()
+	for !b.IsOver() {
+		i := e.rand.Intn(len(moves))
+		b.Play(moves[i])
+		moves[i] = moves[len(moves)-1]
+		moves = moves[:len(moves)-1]
+	}
+	return b.Winner()
+}
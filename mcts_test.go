@@ -0,0 +1,105 @@
+package ttt
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Play a game between MCTS and random moves and return the winner
+func playRandom(e *MCTSEngine, b *Board, engineMark Mark, r *rand.Rand) Mark {
+	for !b.IsOver() {
+		if b.ToMove == engineMark {
+			p, _ := e.Search(b, time.Second)
+			b.Play(p)
+		} else {
+			moves := b.LegalMoves()
+			b.Play(moves[r.Intn(len(moves))])
+		}
+	}
+	return b.Winner()
+}
+
+func TestMCTSNeverLosesToRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		for _, m := range []Mark{MarkX, MarkO} {
+			e := NewMCTSEngine(int64(i))
+			e.Playouts = 2000
+			winner := playRandom(e, NewBoard(3, 3, 3), m, r)
+			assert.NotEqual(t, winner, m.Other())
+		}
+	}
+}
+
+func TestMCTSBigBoard(t *testing.T) {
+	// X has four in a row with one end blocked, and O has three
+	b := NewBoard(7, 7, 5)
+	moves := []Position{{1, 3}, {0, 3}, {2, 3}, {1, 5}, {3, 3}, {2, 5},
+		{4, 3}, {3, 5}}
+	for _, p := range moves {
+		b.Play(p)
+	}
+	e := NewMCTSEngine(1)
+	e.Playouts = 20000
+
+	// X wins at once
+	p, rate := e.Search(b.Clone(), time.Second)
+	assert.Equal(t, p, Position{5, 3})
+	assert.True(t, rate > 0.9)
+
+	// O blocks the only cell it can
+	b.Play(Position{6, 6})
+	p, rate = e.Search(b.Clone(), time.Second)
+	assert.Equal(t, p, Position{5, 3})
+	assert.True(t, rate < 0.9)
+
+	// and beats random moves
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 4; i++ {
+		e := NewMCTSEngine(int64(i))
+		e.Playouts = 2000
+		m := []Mark{MarkX, MarkO}[i%2]
+		assert.Equal(t, playRandom(e, NewBoard(7, 7, 5), m, r), m)
+	}
+}
+
+func TestMCTSReusesTree(t *testing.T) {
+	b := NewBoard(3, 3, 3)
+	e := NewMCTSEngine(1)
+	e.Playouts = 500
+	p, _ := e.Search(b, time.Second)
+	b.Play(p)
+	child := e.tree.children[0]
+	b.Play(child.move)
+	assert.Equal(t, e.reuse(b), child)
+	assert.Nil(t, child.parent)
+	// an unrelated board starts over
+	assert.Nil(t, e.reuse(NewBoard(3, 3, 3)))
+}
+
+func TestMCTSBudget(t *testing.T) {
+	e := NewMCTSEngine(1)
+	start := time.Now()
+	p, _ := e.Search(NewBoard(7, 7, 5), 50*time.Millisecond)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, NewBoard(7, 7, 5).Contains(p))
+
+	// a legal move even without any time
+	g, _ := ParseGame("X1O/1X1/3 O")
+	b := BoardFromGame(g)
+	p, _ = NewMCTSEngine(1).Search(b, 0)
+	assert.Equal(t, b.Get(p), MarkEmpty)
+}
+
+func TestMCTSEngineMove(t *testing.T) {
+	e, err := NewEngine(EngineMCTS)
+	assert.Nil(t, err)
+	e.(*MCTSEngine).Playouts = 1000
+	g, _ := ParseGame("X1O/1X1/3 O")
+	r, scored := e.Move(g, time.Second)
+	assert.False(t, scored)
+	assert.Equal(t, r.Pos, Position{2, 2})
+}
@@ -0,0 +1,355 @@
+package ttt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A game record is written much like a chess PGN: tags in brackets,
+// then the moves numbered in pairs and the result.
+//
+//	[X "Adam"]
+//	[O "Eve"]
+//	[Date "2015.06.01"]
+//	[Variant "Standard"]
+//	[Size "3x3"]
+//	[TimeControl "-"]
+//	[Result "1-0"]
+//
+//	1. b2 a1 2. c1 a3 3. a2 c3 4. b3 {forced} b1 5. c2 1-0
+//
+// A cell is a column letter and a row number, a1 being the top left
+// cell like in the line protocol. Comments in braces follow the move
+// they are about.
+const (
+	RecordExt = ".ttt"
+
+	ResultXWins   = "1-0"
+	ResultOWins   = "0-1"
+	ResultDraw    = "1/2-1/2"
+	ResultUnknown = "*"
+
+	VariantStandard = "Standard"
+
+	recordDateLayout = "2006.01.02"
+	recordLineLength = 79
+)
+
+var (
+	errBadNotation = errors.New("Bad cell notation")
+	errBadTag      = errors.New("Bad tag")
+	errBadResult   = errors.New("Bad result")
+)
+
+// Tags every record has, in the order they are written
+var recordTags = []string{"X", "O", "Date", "Variant", "Size",
+	"TimeControl", "Result"}
+
+// Name of a cell, such as b2
+func (p Position) Notation() string {
+	return string(rune('a'+p.X)) + strconv.Itoa(p.Y+1)
+}
+
+func ParseNotation(s string) (Position, error) {
+	if len(s) < 2 || s[0] < 'a' || s[0] > 'z' {
+		return Position{}, errBadNotation
+	}
+	row, err := strconv.Atoi(s[1:])
+	if err != nil || row < 1 {
+		return Position{}, errBadNotation
+	}
+	return Position{X: int(s[0] - 'a'), Y: row - 1}, nil
+}
+
+// Result of a game won by a mark, or drawn for MarkEmpty
+func ResultOf(winner Mark) string {
+	switch winner {
+	case MarkX:
+		return ResultXWins
+	case MarkO:
+		return ResultOWins
+	}
+	return ResultDraw
+}
+
+func isResult(s string) bool {
+	return s == ResultXWins || s == ResultOWins || s == ResultDraw ||
+		s == ResultUnknown
+}
+
+type Record struct {
+	X           string
+	O           string
+	Date        time.Time
+	Variant     string
+	Width       int
+	Height      int
+	TimeControl string
+	Result      string
+	// Any other tags
+	Tags  map[string]string
+	Moves []Position
+	// Comments by the index of the move they follow
+	Comments map[int]string
+}
+
+// A record of a standard game about to start
+func NewRecord(x, o string) *Record {
+	return &Record{
+		X:           x,
+		O:           o,
+		Date:        time.Now(),
+		Variant:     VariantStandard,
+		Width:       Size,
+		Height:      Size,
+		TimeControl: "-",
+		Result:      ResultUnknown,
+		Tags:        make(map[string]string),
+		Comments:    make(map[int]string),
+	}
+}
+
+func (r *Record) tag(name string) string {
+	switch name {
+	case "X":
+		return r.X
+	case "O":
+		return r.O
+	case "Date":
+		return r.Date.Format(recordDateLayout)
+	case "Variant":
+		return r.Variant
+	case "Size":
+		return strconv.Itoa(r.Width) + "x" + strconv.Itoa(r.Height)
+	case "TimeControl":
+		return r.TimeControl
+	case "Result":
+		return r.Result
+	}
+	return r.Tags[name]
+}
+
+func (r *Record) setTag(name, value string) error {
+	var err error
+	switch name {
+	case "X":
+		r.X = value
+	case "O":
+		r.O = value
+	case "Date":
+		r.Date, err = time.Parse(recordDateLayout, value)
+	case "Variant":
+		r.Variant = value
+	case "Size":
+		dims := strings.Split(value, "x")
+		if len(dims) != 2 {
+			return errBadTag
+		}
+		width, err := strconv.Atoi(dims[0])
+		if err != nil {
+			return err
+		}
+		height, err := strconv.Atoi(dims[1])
+		if err != nil {
+			return err
+		} else if width <= 0 || height <= 0 {
+			return errBadTag
+		}
+		r.Width, r.Height = width, height
+	case "TimeControl":
+		r.TimeControl = value
+	case "Result":
+		if !isResult(value) {
+			return errBadResult
+		}
+		r.Result = value
+	default:
+		r.Tags[name] = value
+	}
+	return err
+}
+
+func (r *Record) String() string {
+	var buffer bytes.Buffer
+	names := append([]string{}, recordTags...)
+	extra := []string{}
+	for name := range r.Tags {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range append(names, extra...) {
+		fmt.Fprintf(&buffer, "[%s %s]\n", name, strconv.Quote(r.tag(name)))
+	}
+	buffer.WriteString("\n")
+
+	tokens := []string{}
+	for i, p := range r.Moves {
+		if i%2 == 0 {
+			tokens = append(tokens, strconv.Itoa(i/2+1)+".")
+		}
+		tokens = append(tokens, p.Notation())
+		if c, ok := r.Comments[i]; ok {
+			tokens = append(tokens, "{"+strings.Replace(c, "}", "", -1)+"}")
+		}
+	}
+	tokens = append(tokens, r.Result)
+	length := 0
+	for i, t := range tokens {
+		if i > 0 && length+1+len(t) > recordLineLength {
+			buffer.WriteString("\n")
+			length = 0
+		} else if i > 0 {
+			buffer.WriteString(" ")
+			length++
+		}
+		buffer.WriteString(t)
+		length += len(t)
+	}
+	buffer.WriteString("\n")
+	return buffer.String()
+}
+
+// Parse a tag line such as [X "Adam"]
+func parseTag(line string) (string, string, error) {
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", "", errBadTag
+	}
+	line = strings.TrimSpace(line[1 : len(line)-1])
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return "", "", errBadTag
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(line[i+1:]))
+	if err != nil {
+		return "", "", errBadTag
+	}
+	return line[:i], value, nil
+}
+
+// Split move text into tokens, keeping comments whole
+func splitMoveText(text string) ([]string, error) {
+	tokens := []string{}
+	for {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			return tokens, nil
+		}
+		end := strings.IndexAny(text, " \t\r\n")
+		if text[0] == '{' {
+			end = strings.Index(text, "}") + 1
+			if end == 0 {
+				return nil, errors.New("Unterminated comment")
+			}
+		} else if end < 0 {
+			end = len(text)
+		}
+		tokens = append(tokens, text[:end])
+		text = text[end:]
+	}
+}
+
+// Parse a record and check that its moves can be played
+func ParseRecord(s string) (*Record, error) {
+	r := &Record{
+		Result:   ResultUnknown,
+		Tags:     make(map[string]string),
+		Comments: make(map[int]string),
+	}
+	lines := strings.Split(s, "\n")
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		} else if !strings.HasPrefix(line, "[") {
+			break
+		}
+		name, value, err := parseTag(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if err := r.setTag(name, value); err != nil {
+			return nil, fmt.Errorf("tag %s: %v", name, err)
+		}
+	}
+	if r.Width == 0 || r.Height == 0 {
+		r.Width = Size
+		r.Height = Size
+	}
+
+	tokens, err := splitMoveText(strings.Join(lines[i:], "\n"))
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[Position]bool)
+	result := ""
+	numbered := false
+	for _, t := range tokens {
+		switch {
+		case result != "":
+			return nil, errors.New("Moves after the result")
+		case strings.HasPrefix(t, "{"):
+			if len(r.Moves) == 0 {
+				return nil, errors.New("Comment before any move")
+			}
+			r.Comments[len(r.Moves)-1] = strings.TrimSpace(t[1 : len(t)-1])
+		case strings.HasSuffix(t, "."):
+			n, err := strconv.Atoi(strings.TrimRight(t, "."))
+			if err != nil || n != len(r.Moves)/2+1 || len(r.Moves)%2 != 0 {
+				return nil, errors.New("Bad move number " + t)
+			}
+			numbered = true
+		case isResult(t):
+			result = t
+		default:
+			if len(r.Moves)%2 == 0 && !numbered {
+				return nil, fmt.Errorf("move %d: missing move number",
+					len(r.Moves)+1)
+			}
+			numbered = false
+			p, err := ParseNotation(t)
+			if err != nil {
+				return nil, fmt.Errorf("move %d: %v", len(r.Moves)+1, err)
+			}
+			if p.X >= r.Width || p.Y >= r.Height || taken[p] {
+				return nil, fmt.Errorf("move %d: %s can not be played",
+					len(r.Moves)+1, t)
+			}
+			taken[p] = true
+			r.Moves = append(r.Moves, p)
+		}
+	}
+	if result != "" && result != r.Result {
+		if r.Result != ResultUnknown {
+			return nil, errors.New("Result does not match the Result tag")
+		}
+		r.Result = result
+	}
+	return r, nil
+}
+
+// Play the moves of a standard record on a grid. The grid is returned
+// along with the winner, MarkEmpty if there is none yet.
+func (r *Record) Grid() (*Grid, Mark, error) {
+	var g Grid
+	if r.Width != Size || r.Height != Size {
+		return nil, MarkEmpty, errors.New("Not a standard size record")
+	}
+	m := MarkX
+	for i, p := range r.Moves {
+		g.Set(p, m)
+		if g.HasSameMarksInRows(p, m) {
+			if i != len(r.Moves)-1 {
+				return nil, MarkEmpty, errors.New("Moves after the game is won")
+			}
+			return &g, m, nil
+		}
+		m = m.Other()
+	}
+	return &g, MarkEmpty, nil
+}
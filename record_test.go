@@ -0,0 +1,147 @@
+package ttt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleRecord = `[X "Adam"]
+[O "Eve \"the\" Bot"]
+[Date "2015.06.01"]
+[Variant "Standard"]
+[Size "3x3"]
+[TimeControl "-"]
+[Result "1-0"]
+[Round "r1"]
+
+1. b2 a1 2. c1 a3 3. a2 c3 4. b3 {forced} b1 5. c2 1-0
+`
+
+func TestPositionNotation(t *testing.T) {
+	assert.Equal(t, Position{X: 0, Y: 0}.Notation(), "a1")
+	assert.Equal(t, Position{X: 2, Y: 1}.Notation(), "c2")
+	p, err := ParseNotation("b3")
+	assert.Nil(t, err)
+	assert.Equal(t, p, Position{X: 1, Y: 2})
+	for _, s := range []string{"", "b", "3b", "b0", "B2", "bx"} {
+		_, err = ParseNotation(s)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestResultOf(t *testing.T) {
+	assert.Equal(t, ResultOf(MarkX), ResultXWins)
+	assert.Equal(t, ResultOf(MarkO), ResultOWins)
+	assert.Equal(t, ResultOf(MarkEmpty), ResultDraw)
+}
+
+func TestParseRecord(t *testing.T) {
+	r, err := ParseRecord(sampleRecord)
+	assert.Nil(t, err)
+	assert.Equal(t, r.X, "Adam")
+	assert.Equal(t, r.O, `Eve "the" Bot`)
+	assert.Equal(t, r.Date, time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, r.Width, 3)
+	assert.Equal(t, r.Height, 3)
+	assert.Equal(t, r.Result, ResultXWins)
+	assert.Equal(t, r.Tags, map[string]string{"Round": "r1"})
+	assert.Equal(t, len(r.Moves), 9)
+	assert.Equal(t, r.Moves[0], Position{X: 1, Y: 1})
+	assert.Equal(t, r.Comments, map[int]string{6: "forced"})
+
+	g, winner, err := r.Grid()
+	assert.Nil(t, err)
+	assert.Equal(t, winner, MarkX)
+	assert.True(t, g.IsFull())
+}
+
+func TestParseRecordErrors(t *testing.T) {
+	bad := []string{
+		`[X Adam]`,
+		`[Size "3"]`,
+		`[Size "0x3"]`,
+		`[Size "3x-1"]`,
+		`[Result "2-0"]`,
+		"1. b2 b2",
+		"1. b2 d1",
+		"2. b2",
+		"1. b2 a1 a2",
+		"{early} 1. b2",
+		"1. b2 {open",
+		"1. b2 * a1",
+		"[Result \"0-1\"]\n\n1. b2 1-0",
+	}
+	for _, s := range bad {
+		_, err := ParseRecord(s)
+		assert.NotNil(t, err, s)
+	}
+	r := NewRecord("Adam", "Eve")
+	err := r.setTag("Size", "0x0")
+	assert.Equal(t, err, errBadTag)
+	assert.Equal(t, r.Width, Size)
+
+	// tags are optional
+	r, err = ParseRecord("1. b2 a1 *")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Width, Size)
+	assert.Equal(t, r.Result, ResultUnknown)
+}
+
+func TestRecordString(t *testing.T) {
+	r, err := ParseRecord(sampleRecord)
+	assert.Nil(t, err)
+	assert.Equal(t, r.String(), sampleRecord)
+
+	r = NewRecord("Adam", "Eve")
+	r.Date = time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	r.Moves = []Position{{1, 1}, {0, 0}}
+	r.Comments[1] = "a {corner}"
+	again, err := ParseRecord(r.String())
+	assert.Nil(t, err)
+	assert.Equal(t, again.Moves, r.Moves)
+	assert.Equal(t, again.Comments, map[int]string{1: "a {corner"})
+	assert.Equal(t, again.Date, r.Date)
+	assert.Equal(t, again.Result, ResultUnknown)
+}
+
+func TestRecordStringWraps(t *testing.T) {
+	r := NewRecord("Adam", "Eve")
+	for i := 0; i < 9; i++ {
+		r.Moves = append(r.Moves, Position{X: i % 3, Y: i / 3})
+		r.Comments[i] = "a rather long comment on this move"
+	}
+	for _, line := range splitLines(r.String()) {
+		assert.True(t, len(line) <= recordLineLength, line)
+	}
+}
+
+func splitLines(s string) []string {
+	lines := []string{}
+	start := 0
+	for i, c := range s {
+		if c == '\n' {
+			lines = append(lines, s[start:i])
+			start = i + 1
+		}
+	}
+	return lines
+}
+
+func TestRecordGrid(t *testing.T) {
+	r := NewRecord("Adam", "Eve")
+	r.Moves = []Position{{0, 0}, {1, 0}, {1, 1}, {2, 0}, {2, 2}, {0, 2}}
+	_, _, err := r.Grid()
+	assert.NotNil(t, err)
+
+	r.Moves = r.Moves[:4]
+	g, winner, err := r.Grid()
+	assert.Nil(t, err)
+	assert.Equal(t, winner, MarkEmpty)
+	assert.Equal(t, g.Get(Position{X: 1, Y: 0}), MarkO)
+
+	r.Width = 4
+	_, _, err = r.Grid()
+	assert.NotNil(t, err)
+}
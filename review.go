@@ -0,0 +1,108 @@
+package ttt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// How a move compares to the best one
+const (
+	ClassBest        = "best"
+	ClassInaccuracy  = "inaccuracy"
+	ClassBlunder     = "blunder"
+	ClassMissedWin   = "missed win"
+	ClassMissedBlock = "missed block"
+)
+
+var errNotStandard = errors.New("Only standard games can be reviewed")
+
+// A move of a game next to a best move in its place
+type ReviewedMove struct {
+	Mark   Mark         `json:"mark"`
+	Class  string       `json:"class"`
+	Played MoveAnalysis `json:"played"`
+	Best   MoveAnalysis `json:"best"`
+}
+
+// Like "blunder: loss in 1, b2 draw", or just where a best move leads
+func (m ReviewedMove) Comment() string {
+	if m.Class == ClassBest {
+		return m.Played.String()
+	}
+	return fmt.Sprintf("%s: %s, %s %s", m.Class, m.Played,
+		m.Best.Pos.Notation(), m.Best)
+}
+
+// Letting a won game go is a missed win, and letting the other player
+// win on the next move when it could be stopped is a missed block. Any
+// other move which changes the value is a blunder, and one which only
+// takes longer to win or hastens a loss is an inaccuracy.
+func classify(played, best MoveAnalysis) string {
+	switch {
+	case !best.Better(played):
+		return ClassBest
+	case best.Value == ValueWin && played.Value != ValueWin:
+		return ClassMissedWin
+	case played.Value == ValueLoss && played.Plies == 2:
+		return ClassMissedBlock
+	case played.Value != best.Value:
+		return ClassBlunder
+	}
+	return ClassInaccuracy
+}
+
+// Compare every move of a standard game, X first, with the best ones
+func ReviewGame(moves []Position) ([]ReviewedMove, error) {
+	g := Game{CurrentPlayer: MarkX, NextPlayer: MarkO}
+	review := []ReviewedMove{}
+	for _, p := range moves {
+		if g.Grd.HasWon(MarkX) || g.Grd.HasWon(MarkO) || g.Grd.IsFull() {
+			return nil, errBoardOver
+		}
+		a := g.Analyze()
+		played, ok := a.Get(p)
+		if !ok {
+			return nil, errBadMove
+		}
+		best := a.Best()[0]
+		if !best.Better(played) {
+			best = played
+		}
+		review = append(review, ReviewedMove{
+			Mark:   g.CurrentPlayer,
+			Class:  classify(played, best),
+			Played: played,
+			Best:   best,
+		})
+		g.Grd.Set(p, g.CurrentPlayer)
+		g.SwitchTurn()
+	}
+	return review, nil
+}
+
+// Review the moves of the record
+func (r *Record) Review() ([]ReviewedMove, error) {
+	if r.Width != Size || r.Height != Size ||
+		r.Variant != "" && r.Variant != VariantStandard {
+		return nil, errNotStandard
+	}
+	return ReviewGame(r.Moves)
+}
+
+// Comment on the moves which were not best, after any comments the
+// record has
+func (r *Record) Annotate(review []ReviewedMove) {
+	if r.Comments == nil {
+		r.Comments = make(map[int]string)
+	}
+	for i, m := range review {
+		if m.Class == ClassBest {
+			continue
+		}
+		if c := r.Comments[i]; c != "" {
+			r.Comments[i] = c + "; " + m.Comment()
+		} else {
+			r.Comments[i] = m.Comment()
+		}
+	}
+}
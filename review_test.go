@@ -0,0 +1,69 @@
+package ttt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReviewGame(t *testing.T) {
+	r, err := ParseRecord("1. b2 b1 2. a1 c3 3. c1 a3 4. a2 *")
+	assert.Nil(t, err)
+	review, err := r.Review()
+	assert.Nil(t, err)
+	classes := []string{}
+	for _, m := range review {
+		classes = append(classes, m.Class)
+	}
+	assert.Equal(t, classes, []string{ClassBest, ClassBlunder, ClassBest,
+		ClassBest, ClassMissedWin, ClassBest, ClassMissedBlock})
+	assert.Equal(t, review[1], ReviewedMove{
+		Mark:   MarkO,
+		Class:  ClassBlunder,
+		Played: MoveAnalysis{Position{1, 0}, ValueLoss, 6},
+		Best:   MoveAnalysis{Pos: Position{0, 0}},
+	})
+	assert.Equal(t, review[1].Comment(), "blunder: loss in 3, a1 draw")
+	assert.Equal(t, review[4].Comment(), "missed win: draw, a2 win in 2")
+	assert.Equal(t, review[2].Comment(), "win in 3")
+
+	r.Annotate(review)
+	assert.Equal(t, len(r.Comments), 3)
+	r, err = ParseRecord(r.String())
+	assert.Nil(t, err)
+	assert.Equal(t, r.Comments[6], "missed block: loss in 1, b3 draw")
+
+	_, err = ReviewGame([]Position{{1, 1}, {1, 1}})
+	assert.Equal(t, err, errBadMove)
+	_, err = ReviewGame([]Position{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {0, 2},
+		{2, 2}})
+	assert.Equal(t, err, errBoardOver)
+	r.Width = 4
+	_, err = r.Review()
+	assert.Equal(t, err, errNotStandard)
+}
+
+func TestClassify(t *testing.T) {
+	win1 := MoveAnalysis{Value: ValueWin, Plies: 1}
+	win3 := MoveAnalysis{Value: ValueWin, Plies: 3}
+	draw := MoveAnalysis{}
+	loss2 := MoveAnalysis{Value: ValueLoss, Plies: 2}
+	loss4 := MoveAnalysis{Value: ValueLoss, Plies: 4}
+	assert.Equal(t, classify(win3, win3), ClassBest)
+	assert.Equal(t, classify(win3, win1), ClassInaccuracy)
+	assert.Equal(t, classify(loss2, loss4), ClassMissedBlock)
+	assert.Equal(t, classify(draw, win3), ClassMissedWin)
+	assert.Equal(t, classify(loss4, draw), ClassBlunder)
+}
+
+func TestRecordAnnotate(t *testing.T) {
+	r := NewRecord("Adam", "Eve")
+	r.Comments[0] = "forced"
+	r.Annotate([]ReviewedMove{
+		{Class: ClassBlunder, Played: MoveAnalysis{Value: ValueLoss, Plies: 2},
+			Best: MoveAnalysis{Pos: Position{1, 1}}},
+		{Class: ClassBest},
+	})
+	assert.Equal(t, r.Comments, map[int]string{
+		0: "forced; blunder: loss in 1, b2 draw"})
+}
@@ -0,0 +1,301 @@
+package ttt
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	EngineDeepening = "deepening"
+
+	// Score of a win on the next move. Wins further away score one less
+	// per move, so the search takes the quickest win and the slowest
+	// loss.
+	WinScore = 1000
+
+	// Nodes searched between looks at the context
+	searchCheckNodes = 1024
+)
+
+func init() {
+	RegisterEngine(EngineDeepening, func() Engine { return &DeepeningEngine{} })
+}
+
+// The outcome of a search
+type SearchResult struct {
+	Pos Position
+	// From the point of view of the player to move: above 0 is a win,
+	// below 0 a loss and 0 a draw or not known yet
+	Score int
+	// Moves ahead of the last complete iteration, 0 if none completed
+	Depth int
+	// Whether the score is known to be right, which is when every line
+	// was searched to the end
+	Exact bool
+}
+
+// Moves closer to the center first: they are usually better, and
+// alpha-beta cuts more when good moves come first
+func orderedMoves(b playable) []Position {
+	moves := b.LegalMoves()
+	width, height := b.size()
+	dist := func(p Position) int {
+		dx, dy := 2*p.X-(width-1), 2*p.Y-(height-1)
+		if dx < 0 {
+			dx = -dx
+		}
+		if dy < 0 {
+			dy = -dy
+		}
+		if dx > dy {
+			return dx
+		}
+		return dy
+	}
+	// insertion sort keeps the row by row order for equal distances
+	for i := 1; i < len(moves); i++ {
+		for j := i; j > 0 && dist(moves[j]) < dist(moves[j-1]); j-- {
+			moves[j], moves[j-1] = moves[j-1], moves[j]
+		}
+	}
+	return moves
+}
+
+const (
+	boundExact = iota
+	// The score is at least the one stored
+	boundLower
+	// The score is at most the one stored
+	boundUpper
+)
+
+// A position searched before in the same iteration
+type searchEntry struct {
+	score int
+	bound int
+}
+
+// State shared by the workers of one iteration
+type searcher struct {
+	ctx context.Context
+	// Set when a line was cut at the depth limit
+	cut   bool
+	cutMu sync.Mutex
+	// Transposition table of standard boards by canonical grid. A
+	// position is always the same number of moves from the root, so
+	// its entry holds for the whole iteration.
+	table   map[positionKey]searchEntry
+	tableMu sync.Mutex
+}
+
+func newSearcher(ctx context.Context) *searcher {
+	return &searcher{
+		ctx:   ctx,
+		table: make(map[positionKey]searchEntry),
+	}
+}
+
+func (s *searcher) lookup(key positionKey) (searchEntry, bool) {
+	s.tableMu.Lock()
+	defer s.tableMu.Unlock()
+	e, ok := s.table[key]
+	return e, ok
+}
+
+func (s *searcher) store(key positionKey, e searchEntry) {
+	s.tableMu.Lock()
+	s.table[key] = e
+	s.tableMu.Unlock()
+}
+
+func (s *searcher) setCut() {
+	s.cutMu.Lock()
+	s.cut = true
+	s.cutMu.Unlock()
+}
+
+// Negamax with alpha-beta to depth moves ahead. ply is the number of
+// moves from the root. ok is false if the context was done.
+func (s *searcher) negamax(b playable, depth, ply, alpha, beta int, nodes *int) (score int, ok bool) {
+	*nodes++
+	if *nodes%searchCheckNodes == 0 && s.ctx.Err() != nil {
+		return 0, false
+	}
+	if b.Winner() != MarkEmpty {
+		// the player who just moved won
+		return -(WinScore - ply), true
+	}
+	if b.IsFull() {
+		return 0, true
+	}
+	if depth == 0 {
+		s.setCut()
+		return 0, true
+	}
+	key, keyed := b.canonicalKey()
+	if keyed {
+		if e, ok := s.lookup(key); ok {
+			switch {
+			case e.bound == boundExact,
+				e.bound == boundLower && e.score >= beta,
+				e.bound == boundUpper && e.score <= alpha:
+				return e.score, true
+			}
+		}
+	}
+	alphaOrig := alpha
+	best := -WinScore
+	for _, p := range orderedMoves(b) {
+		b.Play(p)
+		v, ok := s.negamax(b, depth-1, ply+1, -beta, -alpha, nodes)
+		b.Undo(p)
+		if !ok {
+			return 0, false
+		}
+		v = -v
+		if v > best {
+			best = v
+		}
+		if v > alpha {
+			alpha = v
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	if keyed {
+		e := searchEntry{score: best}
+		switch {
+		case best <= alphaOrig:
+			e.bound = boundUpper
+		case best >= beta:
+			e.bound = boundLower
+		}
+		s.store(key, e)
+	}
+	return best, true
+}
+
+// Search every root move depth moves ahead with workers goroutines.
+// The root moves are handed out one at a time, and each worker starts
+// from the best score found so far.
+func (s *searcher) root(b playable, moves []Position, depth, workers int) (SearchResult, bool) {
+	var (
+		mu        sync.Mutex
+		best      = SearchResult{Score: -WinScore - 1}
+		bestIndex int
+		canceled  bool
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan int, len(moves))
+	for i := range moves {
+		jobs <- i
+	}
+	close(jobs)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nb := b.clone()
+			nodes := 0
+			for i := range jobs {
+				// one below the best, so moves as good as it get an
+				// exact score too
+				mu.Lock()
+				alpha := best.Score - 1
+				mu.Unlock()
+				if alpha < -WinScore {
+					alpha = -WinScore
+				}
+				p := moves[i]
+				nb.Play(p)
+				v, ok := s.negamax(nb, depth-1, 1, -WinScore, -alpha, &nodes)
+				nb.Undo(p)
+				mu.Lock()
+				if !ok {
+					canceled = true
+					mu.Unlock()
+					return
+				}
+				v = -v
+				// ties go to the earlier move so the result does not
+				// depend on which worker finished first
+				if v > best.Score || v == best.Score && i < bestIndex {
+					best = SearchResult{Pos: p, Score: v}
+					bestIndex = i
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if canceled {
+		return SearchResult{}, false
+	}
+	best.Depth = depth
+	return best, true
+}
+
+// Search for the best move of the player to move on b, which must not
+// be over. The search goes one move deeper at a time until the result
+// is exact or ctx is done, and the result of the deepest complete
+// iteration is returned. Root moves are split across workers
+// goroutines, or one per CPU when workers is 0.
+func Search(ctx context.Context, b *Board, workers int) SearchResult {
+	return search(ctx, playableOf(b), workers)
+}
+
+func search(ctx context.Context, b playable, workers int) SearchResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	moves := orderedMoves(b)
+	best := SearchResult{}
+	if len(moves) > 0 {
+		best.Pos = moves[0]
+	}
+	for depth := 1; depth <= len(moves) && ctx.Err() == nil; depth++ {
+		s := newSearcher(ctx)
+		r, ok := s.root(b, moves, depth, workers)
+		if !ok {
+			break
+		}
+		best = r
+		// a won or lost position needs no deeper look
+		if !s.cut || r.Score != 0 {
+			best.Exact = true
+			break
+		}
+		// try the best move first next time
+		for i, p := range moves {
+			if p == r.Pos {
+				copy(moves[1:i+1], moves[:i])
+				moves[0] = p
+				break
+			}
+		}
+	}
+	return best
+}
+
+// Iterative deepening search which stops when the budget is spent
+type DeepeningEngine struct {
+	// Goroutines to search with, one per CPU when 0
+	Workers int
+}
+
+func (e *DeepeningEngine) Move(g Game, budget time.Duration) (GameResult, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	r := Search(ctx, BoardFromGame(g), e.Workers)
+	score := 0
+	switch {
+	case r.Score > 0:
+		score = Score
+	case r.Score < 0:
+		score = -Score
+	}
+	return GameResult{score, r.Pos}, r.Exact
+}
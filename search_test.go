@@ -0,0 +1,111 @@
+package ttt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchToTheEnd(t *testing.T) {
+	r := Search(context.Background(), NewBoard(3, 3, 3), 0)
+	assert.Equal(t, r, SearchResult{Pos: Position{1, 1}, Score: 0, Depth: 9,
+		Exact: true})
+
+	// X wins in three moves with a fork
+	g, _ := ParseGame("XOX/3/O2 X")
+	r = Search(context.Background(), BoardFromGame(g), 2)
+	assert.Equal(t, r.Pos, Position{2, 2})
+	assert.Equal(t, r.Score, WinScore-3)
+	assert.True(t, r.Exact)
+
+	// O loses whatever it plays
+	g, _ = ParseGame("XX1/1XO/O2 O")
+	r = Search(context.Background(), BoardFromGame(g), 2)
+	assert.Equal(t, r.Score, -(WinScore - 2))
+	assert.True(t, r.Exact)
+}
+
+func TestSearchWorkers(t *testing.T) {
+	g, _ := ParseGame("X2/1O1/2X O")
+	one := Search(context.Background(), BoardFromGame(g), 1)
+	for _, workers := range []int{2, 4, 8} {
+		assert.Equal(t, Search(context.Background(), BoardFromGame(g), workers),
+			one)
+	}
+}
+
+func TestSearchTransposition(t *testing.T) {
+	s := newSearcher(context.Background())
+	g, _ := ParseGame("X2/3/3 O")
+	nodes := 0
+	v, ok := s.negamax(BoardFromGame(g), 8, 1, -WinScore, WinScore, &nodes)
+	assert.True(t, ok)
+	assert.True(t, nodes > 1)
+
+	// the opposite corner turns into the same grid
+	g, _ = ParseGame("3/3/2X O")
+	nodes = 0
+	mv, ok := s.negamax(BoardFromGame(g), 8, 1, -WinScore, WinScore, &nodes)
+	assert.True(t, ok)
+	assert.Equal(t, nodes, 1)
+	assert.Equal(t, mv, v)
+
+	// boards other than the standard one are not kept
+	_, keyed := NewBoard(4, 4, 3).canonicalKey()
+	assert.False(t, keyed)
+}
+
+func TestSearchBigBoard(t *testing.T) {
+	// X has four in a row with one end blocked
+	b := NewBoard(7, 7, 5)
+	moves := []Position{{1, 3}, {0, 3}, {2, 3}, {1, 5}, {3, 3}, {2, 5},
+		{4, 3}, {3, 5}}
+	for _, p := range moves {
+		b.Play(p)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r := Search(ctx, b, 0)
+	assert.Equal(t, r.Pos, Position{5, 3})
+	assert.Equal(t, r.Score, WinScore-1)
+
+	// O blocks
+	b.Play(Position{6, 6})
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r = Search(ctx, b, 0)
+	assert.Equal(t, r.Pos, Position{5, 3})
+}
+
+func TestSearchDeadline(t *testing.T) {
+	b := NewBoard(15, 15, 5)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	r := Search(ctx, b, 0)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, r.Depth > 0)
+	assert.False(t, r.Exact)
+	assert.Equal(t, r.Pos, Position{7, 7})
+
+	// nothing is searched once canceled, but a move is still given
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	r = Search(ctx, b, 0)
+	assert.Equal(t, r, SearchResult{Pos: Position{7, 7}})
+}
+
+func TestDeepeningEngine(t *testing.T) {
+	g, _ := ParseGame("XX1/OO1/X2 O")
+	r, scored := (&DeepeningEngine{}).Move(g, time.Second)
+	assert.True(t, scored)
+	assert.Equal(t, r, GameResult{Score, Position{2, 1}})
+
+	r, scored = (&DeepeningEngine{}).Move(Game{CurrentPlayer: MarkX,
+		NextPlayer: MarkO, Grd: Grid{}}, time.Nanosecond)
+	assert.False(t, scored)
+	assert.Equal(t, r.Pos, Position{1, 1})
+}
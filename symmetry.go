@@ -0,0 +1,94 @@
+package ttt
+
+// One of the 8 ways to turn or flip a square board onto itself
+type Symmetry int
+
+const (
+	Identity Symmetry = iota
+	Rotate90
+	Rotate180
+	Rotate270
+	// Flip left and right
+	FlipX
+	// Flip top and bottom
+	FlipY
+	// Flip on the diagonal through the top left corner
+	Transpose
+	// Flip on the other diagonal
+	AntiTranspose
+)
+
+// All of them, Identity first
+var Symmetries = []Symmetry{Identity, Rotate90, Rotate180, Rotate270,
+	FlipX, FlipY, Transpose, AntiTranspose}
+
+// Where p goes on a size by size board. Rotations are clockwise.
+func (s Symmetry) Apply(p Position, size int) Position {
+	n := size - 1
+	switch s {
+	case Rotate90:
+		return Position{n - p.Y, p.X}
+	case Rotate180:
+		return Position{n - p.X, n - p.Y}
+	case Rotate270:
+		return Position{p.Y, n - p.X}
+	case FlipX:
+		return Position{n - p.X, p.Y}
+	case FlipY:
+		return Position{p.X, n - p.Y}
+	case Transpose:
+		return Position{p.Y, p.X}
+	case AntiTranspose:
+		return Position{n - p.Y, n - p.X}
+	}
+	return p
+}
+
+// The symmetry undoing s
+func (s Symmetry) Inverse() Symmetry {
+	switch s {
+	case Rotate90:
+		return Rotate270
+	case Rotate270:
+		return Rotate90
+	}
+	return s
+}
+
+// The grid turned or flipped by s
+func (g *Grid) Transform(s Symmetry) Grid {
+	var t Grid
+	for x, l := range g {
+		for y, m := range l {
+			t.Set(s.Apply(Position{x, y}, Size), m)
+		}
+	}
+	return t
+}
+
+// Whether g comes before o, comparing cells row by row
+func (g *Grid) less(o *Grid) bool {
+	for y := 0; y < Size; y++ {
+		for x := 0; x < Size; x++ {
+			if g[x][y] != o[x][y] {
+				return g[x][y] < o[x][y]
+			}
+		}
+	}
+	return false
+}
+
+// The same grid for all grids which turn or flip into each other, and
+// the symmetry taking g there. Moves found on the canonical grid are
+// mapped back to g with the inverse of the symmetry. Game.Analyze and
+// the transposition table of Search keep positions by canonical grid.
+func (g *Grid) Canonical() (Grid, Symmetry) {
+	best, bestSym := *g, Identity
+	for _, s := range Symmetries[1:] {
+		t := g.Transform(s)
+		if t.less(&best) {
+			best, bestSym = t, s
+		}
+	}
+	return best, bestSym
+}
@@ -0,0 +1,69 @@
+package ttt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSymmetryApply(t *testing.T) {
+	p := Position{0, 1}
+	expected := map[Symmetry]Position{
+		Identity:      {0, 1},
+		Rotate90:      {1, 0},
+		Rotate180:     {2, 1},
+		Rotate270:     {1, 2},
+		FlipX:         {2, 1},
+		FlipY:         {0, 1},
+		Transpose:     {1, 0},
+		AntiTranspose: {1, 2},
+	}
+	for _, s := range Symmetries {
+		assert.Equal(t, s.Apply(p, Size), expected[s])
+		assert.Equal(t, s.Inverse().Apply(s.Apply(p, Size), Size), p)
+	}
+	// bigger boards too
+	assert.Equal(t, Rotate90.Apply(Position{0, 0}, 8), Position{7, 0})
+}
+
+func TestGridCanonical(t *testing.T) {
+	// X in any corner and O next to it on either side
+	corners := []string{"XO1/3/3 X", "X2/O2/3 X", "1OX/3/3 X", "2X/2O/3 X",
+		"3/3/XO1 X", "3/O2/X2 X", "3/3/1OX X", "3/2O/2X X"}
+	var first Grid
+	for i, c := range corners {
+		g, err := ParseGame(c)
+		assert.Nil(t, err, c)
+		canonical, sym := g.Grd.Canonical()
+		if i == 0 {
+			first = canonical
+		}
+		assert.Equal(t, canonical, first, c)
+		assert.Equal(t, canonical, g.Grd.Transform(sym), c)
+		back := canonical.Transform(sym.Inverse())
+		assert.Equal(t, back, g.Grd, c)
+	}
+	// the center is different
+	g, _ := ParseGame("3/1XO/3 X")
+	canonical, _ := g.Grd.Canonical()
+	assert.NotEqual(t, canonical, first)
+}
+
+func TestGameAnalyzeSymmetric(t *testing.T) {
+	// the same position turned around gets the same answers, turned
+	// around too
+	g, _ := ParseGame("X1O/1X1/3 O")
+	a := g.Analyze()
+	for _, s := range Symmetries {
+		tg := g
+		tg.Grd = g.Grd.Transform(s)
+		ta := tg.Analyze()
+		assert.Equal(t, len(ta), len(a))
+		for _, m := range a {
+			tm, ok := ta.Get(s.Apply(m.Pos, Size))
+			assert.True(t, ok)
+			assert.Equal(t, tm.Value, m.Value)
+			assert.Equal(t, tm.Plies, m.Plies)
+		}
+	}
+}
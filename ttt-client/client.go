@@ -3,9 +3,12 @@ package main
 import (
 	"bytes"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
@@ -81,20 +84,48 @@ type TTTClient struct {
 	Name      string
 	ID        string
 	Score     int
+	Mark      ttt.Mark
 	Conn      *websocket.Conn
 	VSID      string
 	VSName    string
 	VSScore   int
+	VSMark    ttt.Mark
 	RoundID   string
 	Status    string
 	CursorPos ttt.Position
 	Grid      ttt.Grid
+	Seq       int
+	Latency   time.Duration
+	ActionSeq int
+	// Last notice from the server
+	Notice string
+	// Address of the server and session to resume there after losing
+	// the connection
+	Server string
+	Token  string
+	// Moves of the round in the order they were played
+	Moves []ttt.Position
+	// Set when a recorded game is shown instead of playing
+	Replay *Replay
+	// Level of the bots to play against
+	Level string
+	// Whether the round allows hints, and the cell of the last one
+	Hints string
+	Hint  *ttt.Position
+	// Review of the moves once the round is over
+	Review []ttt.ReviewedMove
+
+	// Move sent to the server and not acknowledged yet
+	pending *ttt.PlayerAction
+	// Both the key event loop and the listener send actions, so
+	// writes and the pending move are guarded
+	lock sync.Mutex
 }
 
-func (tttc *TTTClient) nameToRune(s string) rune {
-	if s != "" && s == tttc.ID {
+func (tttc *TTTClient) markToRune(m ttt.Mark) rune {
+	if m != ttt.MarkEmpty && m == tttc.Mark {
 		return ttt.MyRune
-	} else if s != "" && s == tttc.VSID {
+	} else if m != ttt.MarkEmpty && m == tttc.VSMark {
 		return ttt.OtherRune
 	} else {
 		return ttt.SpecialRune
@@ -104,7 +135,7 @@ func (tttc *TTTClient) nameToRune(s string) rune {
 // Check if a cell is available
 func (tttc *TTTClient) cellIsPinnable(p ttt.Position) bool {
 	return ttt.IsValidPosition(p) && tttc.RoundID != "" &&
-		tttc.Grid.Get(p) == ""
+		tttc.Grid.Get(p) == ttt.MarkEmpty
 }
 
 func (tttc *TTTClient) MoveCursor(direction string) error {
@@ -145,7 +176,7 @@ func (tttc *TTTClient) isYourTurn() bool {
 
 func (tttc *TTTClient) PinCursor(r rune) bool {
 	if tttc.cellIsPinnable(tttc.CursorPos) && tttc.isYourTurn() {
-		tttc.Grid.Set(tttc.CursorPos, tttc.ID)
+		tttc.Grid.Set(tttc.CursorPos, tttc.Mark)
 		err := tttc.SendPin(tttc.CursorPos)
 		return err == nil
 	} else {
@@ -155,9 +186,12 @@ func (tttc *TTTClient) PinCursor(r rune) bool {
 
 func (tttc *TTTClient) drawCells() {
 	for x, l := range tttc.Grid {
-		for y, s := range l {
+		for y, m := range l {
 			p := ttt.Position{x, y}
-			r := tttc.nameToRune(s)
+			r := tttc.markToRune(m)
+			if m == ttt.MarkEmpty && tttc.Hint != nil && *tttc.Hint == p {
+				r = ttt.HintRune
+			}
 			setCell(p, r)
 		}
 	}
@@ -177,6 +211,19 @@ func (tttc *TTTClient) userScores() string {
 	return buffer.String()
 }
 
+// Status with the round-trip time to the server once it is known
+func (tttc *TTTClient) statusLine() string {
+	line := tttc.Status
+	if tttc.Latency != 0 {
+		ms := int(tttc.Latency / time.Millisecond)
+		line += " (" + strconv.Itoa(ms) + "ms)"
+	}
+	if tttc.Notice != "" {
+		line += " - " + tttc.Notice
+	}
+	return line
+}
+
 func (tttc *TTTClient) RedrawAll() {
 	termbox.Clear(ttt.ColDef, ttt.ColDef)
 	tbCenter := getTBCenter()
@@ -206,10 +253,16 @@ func (tttc *TTTClient) RedrawAll() {
 	printLines(tbCenter.X, tbUpYPos-2, ttt.Title, ttt.ColDef, true)
 	printLines(tbCenter.X, tbUpYPos+ttt.Height+2, tttc.userScores(),
 		ttt.ColDef, false)
-	printLines(tbCenter.X, tbUpYPos+ttt.Height+4, tttc.Status,
+	printLines(tbCenter.X, tbUpYPos+ttt.Height+4, tttc.statusLine(),
 		termbox.ColorBlue, false)
-	printLines(tbCenter.X, tbUpYPos+ttt.Height+6, ttt.HelpMsg, ttt.ColDef,
-		false)
+	help := ttt.HelpMsg
+	if tttc.Replay != nil {
+		help = ttt.ReplayHelpMsg
+	}
+	printLines(tbCenter.X, tbUpYPos+ttt.Height+6, help, ttt.ColDef, false)
+	// the review goes right of the grid
+	printLines(tbCenter.X+ttt.Width+ttt.XSpan/2, tbUpYPos,
+		tttc.reviewLines(), ttt.ColDef, false)
 
 	tttc.SetCursor(tttc.CursorPos)
 
@@ -224,10 +277,90 @@ func (tttc *TTTClient) Connect(s string) error {
 	if err != nil {
 		return err
 	}
+	tttc.lock.Lock()
 	tttc.Conn = ws
+	tttc.Server = s
+	tttc.lock.Unlock()
+	ws.SetReadDeadline(time.Now().Add(ttt.PongWait))
+	ws.SetPongHandler(tttc.handlePong)
+	return nil
+}
+
+// Ping the server regularly, so that a dead connection is noticed
+// and the round-trip time is known
+func (tttc *TTTClient) Heartbeat() {
+	ticker := time.NewTicker(ttt.PingInterval)
+	defer ticker.Stop()
+	for t := range ticker.C {
+		payload := strconv.FormatInt(t.UnixNano(), 10)
+		err := tttc.Conn.WriteControl(websocket.PingMessage,
+			[]byte(payload), time.Now().Add(ttt.WriteWait))
+		if err != nil {
+			// the listener takes care of reconnecting
+			glog.Warningln("can not ping server", err)
+		}
+	}
+}
+
+func (tttc *TTTClient) handlePong(payload string) error {
+	tttc.Conn.SetReadDeadline(time.Now().Add(ttt.PongWait))
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err == nil {
+		tttc.Latency = time.Since(time.Unix(0, sent))
+	}
 	return nil
 }
 
+func (tttc *TTTClient) send(m *ttt.PlayerAction) error {
+	tttc.lock.Lock()
+	defer tttc.lock.Unlock()
+	return tttc.write(m)
+}
+
+// Number an action and write it to the server. The caller holds the
+// lock.
+func (tttc *TTTClient) write(m *ttt.PlayerAction) error {
+	tttc.ActionSeq++
+	m.Seq = tttc.ActionSeq
+	return tttc.Conn.WriteJSON(m)
+}
+
+// Send the pending move again later if it is still not acknowledged
+func (tttc *TTTClient) retransmitLater(m ttt.PlayerAction, attempt int) {
+	time.AfterFunc(ttt.AckTimeout, func() {
+		tttc.lock.Lock()
+		defer tttc.lock.Unlock()
+		if tttc.pending == nil || tttc.pending.Seq != m.Seq {
+			return
+		}
+		if attempt > ttt.MaxRetransmits {
+			glog.Warningln("move", m.Seq, "was never acknowledged")
+			return
+		}
+		if err := tttc.Conn.WriteJSON(m); err == nil {
+			tttc.retransmitLater(m, attempt+1)
+		}
+	})
+}
+
+// Settle the pending move. A rejected move is taken back from the
+// grid.
+func (tttc *TTTClient) handleAck(ack *ttt.ActionAck) {
+	tttc.lock.Lock()
+	defer tttc.lock.Unlock()
+	if tttc.pending == nil || tttc.pending.Seq != ack.Seq {
+		return
+	}
+	if !ack.OK {
+		glog.Warningln("move rejected:", ack.Reason)
+		pos := tttc.pending.Pos
+		if tttc.Grid.Get(pos) == tttc.Mark {
+			tttc.Grid.Set(pos, ttt.MarkEmpty)
+		}
+	}
+	tttc.pending = nil
+}
+
 func (tttc *TTTClient) SendSimpleCMD(cmd string) error {
 	m := ttt.PlayerAction{
 		RoundID:    tttc.RoundID,
@@ -236,7 +369,7 @@ func (tttc *TTTClient) SendSimpleCMD(cmd string) error {
 		Pos:        ttt.Position{},
 		Cmd:        cmd,
 	}
-	return tttc.Conn.WriteJSON(m)
+	return tttc.send(&m)
 }
 
 func (tttc *TTTClient) SendPin(p ttt.Position) error {
@@ -247,45 +380,240 @@ func (tttc *TTTClient) SendPin(p ttt.Position) error {
 		Pos:        p,
 		Cmd:        ttt.CmdMove,
 	}
-	return tttc.Conn.WriteJSON(m)
+	tttc.lock.Lock()
+	defer tttc.lock.Unlock()
+	if err := tttc.write(&m); err != nil {
+		return err
+	}
+	tttc.pending = &m
+	tttc.retransmitLater(m, 1)
+	return nil
 }
 
 func (tttc *TTTClient) Update(s ttt.PlayerStatus) error {
+	if s.Ack != nil {
+		tttc.handleAck(s.Ack)
+	}
+	if s.Notice != "" {
+		tttc.Notice = s.Notice
+	}
+	if s.Token != "" {
+		tttc.Token = s.Token
+	}
+	if s.Hint != nil {
+		tttc.Hint = s.Hint
+	}
+	if s.Status == "" {
+		// nothing but an acknowledgement or a notice
+		tttc.RedrawAll()
+		return nil
+	}
 	if s.RoundID != "" && tttc.RoundID != s.RoundID &&
 		!ttt.IsOverStatus(tttc.Status) {
 		glog.Warningln("Round IDs do not match")
 		return errors.New("Round IDs do not match")
 	} else {
+		tttc.trackMove(&s)
 		tttc.RoundID = s.RoundID
 	}
 	tttc.ID = s.PlayerID
 	tttc.Score = s.PlayerScore
+	tttc.Mark = s.Mark
 	tttc.VSID = s.VSID
 	tttc.VSName = s.VSName
 	tttc.VSScore = s.VSScore
+	tttc.VSMark = s.VSMark
 	tttc.Status = s.Status
-
-	if s.GridSnap != nil {
-		tttc.Grid = *s.GridSnap
-	} else {
-		var grid ttt.Grid
-		tttc.Grid = grid
+	tttc.Hints = s.Hints
+	// a hint is good for one turn
+	tttc.Hint = nil
+
+	seq, ok := tttc.Grid.Sync(tttc.Seq, &s)
+	tttc.Seq = seq
+	if !ok {
+		glog.Warningln("missed some moves, asking for a snapshot")
+		tttc.SendSimpleCMD(ttt.CmdResync)
 	}
+	tttc.reviewRound()
 	tttc.RedrawAll()
 	return nil
 }
 
+// Remember the moves of the round, forgetting them when a new round
+// starts
+func (tttc *TTTClient) trackMove(s *ttt.PlayerStatus) {
+	if s.RoundID != tttc.RoundID {
+		tttc.Moves = nil
+		tttc.Review = nil
+	}
+	if s.Move != nil && s.Move.Seq == len(tttc.Moves)+1 {
+		tttc.Moves = append(tttc.Moves, s.Move.Pos)
+	}
+}
+
+// Review the moves once the round is won or tied, if none were missed
+func (tttc *TTTClient) reviewRound() {
+	over := tttc.Status == ttt.StatusWin || tttc.Status == ttt.StatusLoss ||
+		tttc.Status == ttt.StatusTie
+	if !over || tttc.Review != nil || len(tttc.Moves) != tttc.Seq {
+		return
+	}
+	review, err := ttt.ReviewGame(tttc.Moves)
+	if err != nil {
+		glog.Warningln("can not review round", tttc.RoundID, err)
+		return
+	}
+	tttc.Review = review
+}
+
+// The reviewed moves, one per line, with a best move after those
+// which were not
+func (tttc *TTTClient) reviewLines() string {
+	lines := []string{}
+	for i, m := range tttc.Review {
+		line := strconv.Itoa(i+1) + ". " + tttc.Moves[i].Notation() + " " +
+			m.Class
+		if m.Class != ttt.ClassBest {
+			line += " (" + m.Best.Pos.Notation() + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Record of the current or last round
+func (tttc *TTTClient) Record() (*ttt.Record, error) {
+	if tttc.RoundID == "" || len(tttc.Moves) == 0 {
+		return nil, errors.New("Nothing to export")
+	}
+	if len(tttc.Moves) < tttc.Seq {
+		return nil, errors.New("Some moves were missed")
+	}
+	r := ttt.NewRecord(tttc.Name, tttc.VSName)
+	if tttc.Mark == ttt.MarkO {
+		r.X, r.O = tttc.VSName, tttc.Name
+	}
+	r.Tags["Round"] = tttc.RoundID
+	r.Moves = append(r.Moves, tttc.Moves...)
+	switch tttc.Status {
+	case ttt.StatusWin:
+		r.Result = ttt.ResultOf(tttc.Mark)
+	case ttt.StatusLoss:
+		r.Result = ttt.ResultOf(tttc.VSMark)
+	case ttt.StatusTie:
+		r.Result = ttt.ResultDraw
+	}
+	r.Annotate(tttc.Review)
+	return r, nil
+}
+
+// Save the record of the round in the working directory
+func (tttc *TTTClient) Export() error {
+	r, err := tttc.Record()
+	if err == nil {
+		name := tttc.RoundID + ttt.RecordExt
+		err = ioutil.WriteFile(name, []byte(r.String()), 0644)
+		if err == nil {
+			tttc.Notice = "Saved " + name
+			return nil
+		}
+	}
+	tttc.Notice = err.Error()
+	return err
+}
+
 func (tttc *TTTClient) Join(withAI bool) error {
 	if !ttt.IsOverStatus(tttc.Status) {
 		glog.Warningln("cannot rematch before this round is over")
 		return errors.New("This round is not over yet.")
 	}
 	if withAI {
-		return tttc.SendSimpleCMD(ttt.CmdJoinAI)
+		m := ttt.PlayerAction{
+			PlayerID:   tttc.ID,
+			PlayerName: tttc.Name,
+			Cmd:        ttt.CmdJoinAI,
+			Level:      tttc.Level,
+		}
+		return tttc.send(&m)
 	}
 	return tttc.SendSimpleCMD(ttt.CmdJoin)
 }
 
+// A best move on the grid for you
+func (tttc *TTTClient) localHint() ttt.Position {
+	g := ttt.Game{
+		CurrentPlayer: tttc.Mark,
+		NextPlayer:    tttc.VSMark,
+		Grd:           tttc.Grid,
+	}
+	return g.Hint()
+}
+
+// Show a good move on your turn. It is worked out here if the round
+// allows it, or asked from the server otherwise; the server is told
+// either way so that it can count hints.
+func (tttc *TTTClient) RequestHint() error {
+	var err error
+	switch {
+	case !tttc.isYourTurn():
+		err = errors.New("Hints are given on your turn")
+	case tttc.Hints == ttt.HintsLocal:
+		hint := tttc.localHint()
+		tttc.Hint = &hint
+		tttc.Notice = "Hint: " + hint.Notation()
+		return tttc.SendSimpleCMD(ttt.CmdHint)
+	case tttc.Hints == ttt.HintsServer:
+		return tttc.SendSimpleCMD(ttt.CmdHint)
+	default:
+		err = errors.New(ttt.ReasonNoHints)
+	}
+	tttc.Notice = err.Error()
+	return err
+}
+
+// Pick the next level of bots
+func (tttc *TTTClient) NextLevel() {
+	next := ttt.Levels[0]
+	for i, l := range ttt.Levels[:len(ttt.Levels)-1] {
+		if l == tttc.Level {
+			next = ttt.Levels[i+1]
+		}
+	}
+	tttc.Level = next
+	tttc.Notice = "AI level: " + tttc.Level
+}
+
+// Pick up the session after connecting again
+func (tttc *TTTClient) Resume() error {
+	m := ttt.PlayerAction{
+		PlayerName: tttc.Name,
+		Cmd:        ttt.CmdResume,
+		Token:      tttc.Token,
+	}
+	return tttc.send(&m)
+}
+
+// Time to wait before the next attempt to connect
+func nextReconnectWait(wait time.Duration) time.Duration {
+	wait *= 2
+	if wait > ttt.MaxReconnectWait {
+		return ttt.MaxReconnectWait
+	}
+	return wait
+}
+
+// Connect to the server again until it works
+func (tttc *TTTClient) Reconnect() {
+	for wait := ttt.ReconnectWait; ; wait = nextReconnectWait(wait) {
+		time.Sleep(wait)
+		err := tttc.Connect(tttc.Server)
+		if err == nil {
+			return
+		}
+		glog.Warningln("can not reconnect", err)
+	}
+}
+
 func (tttc *TTTClient) Quit() error {
 	return tttc.SendSimpleCMD(ttt.CmdQuit)
 }
@@ -302,14 +630,22 @@ func (tttc *TTTClient) Listener() error {
 				PlayerName:  tttc.Name,
 				PlayerID:    tttc.ID,
 				PlayerScore: tttc.Score,
+				Mark:        tttc.Mark,
 				VSID:        tttc.VSID,
 				VSName:      tttc.VSName,
 				VSScore:     tttc.VSScore,
+				VSMark:      tttc.VSMark,
 				Status:      ttt.StatusLossConnection,
+				Seq:         tttc.Seq,
 				GridSnap:    &tttc.Grid,
 			}
 			tttc.Update(status)
-			return nil
+			if tttc.Token == "" {
+				return nil
+			}
+			tttc.Reconnect()
+			tttc.Resume()
+			continue
 		}
 		tttc.Update(status)
 
@@ -330,5 +666,6 @@ func TTTCInit(name string) *TTTClient {
 		tttc.Name = name
 	}
 	tttc.CursorPos = center
+	tttc.Level = ttt.LevelExpert
 	return &tttc
 }
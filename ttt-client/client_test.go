@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/wujiang/tic-tac-toe"
@@ -19,9 +20,13 @@ func teardown() {
 	tttc = &TTTClient{}
 }
 
-func TestTTTCnameToRune(t *testing.T) {
+func TestTTTCmarkToRune(t *testing.T) {
 	setup()
-	assert.Equal(t, tttc.nameToRune(""), ttt.SpecialRune)
+	assert.Equal(t, tttc.markToRune(ttt.MarkEmpty), ttt.SpecialRune)
+	tttc.Mark = ttt.MarkO
+	tttc.VSMark = ttt.MarkX
+	assert.Equal(t, tttc.markToRune(ttt.MarkO), ttt.MyRune)
+	assert.Equal(t, tttc.markToRune(ttt.MarkX), ttt.OtherRune)
 	teardown()
 }
 
@@ -40,3 +45,153 @@ func TestTTTCcellIsPinnableTrue(t *testing.T) {
 	assert.False(t, tttc.cellIsPinnable(ttt.Position{3, 0}))
 	teardown()
 }
+
+func TestTTTCstatusLine(t *testing.T) {
+	setup()
+	tttc.Status = ttt.StatusYourTurn
+	assert.Equal(t, tttc.statusLine(), ttt.StatusYourTurn)
+	tttc.Latency = 42 * time.Millisecond
+	assert.Equal(t, tttc.statusLine(), ttt.StatusYourTurn+" (42ms)")
+	tttc.Notice = ttt.NoticeShutdown
+	assert.Equal(t, tttc.statusLine(),
+		ttt.StatusYourTurn+" (42ms) - "+ttt.NoticeShutdown)
+	teardown()
+}
+
+func TestNextReconnectWait(t *testing.T) {
+	assert.Equal(t, nextReconnectWait(ttt.ReconnectWait),
+		2*ttt.ReconnectWait)
+	assert.Equal(t, nextReconnectWait(ttt.MaxReconnectWait),
+		ttt.MaxReconnectWait)
+}
+
+func TestTTTChandleAck(t *testing.T) {
+	setup()
+	pos := ttt.Position{X: 1, Y: 1}
+	tttc.Mark = ttt.MarkX
+	tttc.Grid.Set(pos, ttt.MarkX)
+	tttc.pending = &ttt.PlayerAction{Pos: pos, Cmd: ttt.CmdMove, Seq: 2}
+
+	// acknowledgements of other actions are ignored
+	tttc.handleAck(&ttt.ActionAck{Seq: 1, OK: false})
+	assert.NotNil(t, tttc.pending)
+	assert.Equal(t, tttc.Grid.Get(pos), ttt.MarkX)
+
+	tttc.handleAck(&ttt.ActionAck{Seq: 2, OK: false,
+		Reason: ttt.ReasonCellTaken})
+	assert.Nil(t, tttc.pending)
+	assert.Equal(t, tttc.Grid.Get(pos), ttt.MarkEmpty)
+
+	tttc.Grid.Set(pos, ttt.MarkX)
+	tttc.pending = &ttt.PlayerAction{Pos: pos, Cmd: ttt.CmdMove, Seq: 3}
+	tttc.handleAck(&ttt.ActionAck{Seq: 3, OK: true})
+	assert.Nil(t, tttc.pending)
+	assert.Equal(t, tttc.Grid.Get(pos), ttt.MarkX)
+	teardown()
+}
+
+func TestTTTCRecord(t *testing.T) {
+	setup()
+	_, err := tttc.Record()
+	assert.NotNil(t, err)
+
+	tttc.Mark = ttt.MarkO
+	tttc.VSMark = ttt.MarkX
+	tttc.VSName = "Eve"
+	moves := []ttt.Position{{X: 1, Y: 1}, {X: 0, Y: 0}, {X: 2, Y: 2}}
+	for i, pos := range moves {
+		tttc.trackMove(&ttt.PlayerStatus{RoundID: "r1",
+			Move: &ttt.MoveEvent{Seq: i + 1, Pos: pos}})
+		tttc.RoundID = "r1"
+		tttc.Seq = i + 1
+	}
+	// a retransmitted move is only tracked once
+	tttc.trackMove(&ttt.PlayerStatus{RoundID: "r1",
+		Move: &ttt.MoveEvent{Seq: 3, Pos: moves[2]}})
+	tttc.Status = ttt.StatusLoss
+
+	r, err := tttc.Record()
+	assert.Nil(t, err)
+	assert.Equal(t, r.X, "Eve")
+	assert.Equal(t, r.O, "Adam")
+	assert.Equal(t, r.Moves, moves)
+	assert.Equal(t, r.Result, ttt.ResultXWins)
+	assert.Equal(t, r.Tags["Round"], "r1")
+
+	tttc.Seq = 4
+	_, err = tttc.Record()
+	assert.NotNil(t, err)
+
+	// a new round starts over
+	tttc.trackMove(&ttt.PlayerStatus{RoundID: "r2"})
+	assert.Equal(t, len(tttc.Moves), 0)
+	teardown()
+}
+
+func TestTTTCNextLevel(t *testing.T) {
+	setup()
+	tttc.Level = ttt.LevelExpert
+	tttc.NextLevel()
+	assert.Equal(t, tttc.Level, ttt.LevelBeginner)
+	assert.Equal(t, tttc.Notice, "AI level: beginner")
+	tttc.NextLevel()
+	assert.Equal(t, tttc.Level, ttt.LevelIntermediate)
+	tttc.Level = ""
+	tttc.NextLevel()
+	assert.Equal(t, tttc.Level, ttt.LevelBeginner)
+	teardown()
+}
+
+func TestTTTCRequestHint(t *testing.T) {
+	setup()
+	tttc.ID = "p1"
+	tttc.Status = ttt.StatusWaitTurn
+	tttc.Hints = ttt.HintsLocal
+	assert.NotNil(t, tttc.RequestHint())
+	assert.Equal(t, tttc.Notice, "Hints are given on your turn")
+
+	tttc.Status = ttt.StatusYourTurn
+	tttc.Hints = ttt.HintsOff
+	assert.NotNil(t, tttc.RequestHint())
+	assert.Equal(t, tttc.Notice, ttt.ReasonNoHints)
+	assert.Nil(t, tttc.Hint)
+	teardown()
+}
+
+func TestTTTClocalHint(t *testing.T) {
+	setup()
+	tttc.Mark = ttt.MarkO
+	tttc.VSMark = ttt.MarkX
+	g, _ := ttt.ParseGame("X1O/1X1/3 O")
+	tttc.Grid = g.Grd
+	assert.Equal(t, tttc.localHint(), ttt.Position{X: 2, Y: 2})
+	teardown()
+}
+
+func TestTTTCReview(t *testing.T) {
+	setup()
+	// O misses the block in the bottom row
+	tttc.Moves = []ttt.Position{{X: 0, Y: 0}, {X: 1, Y: 1}, {X: 2, Y: 2},
+		{X: 1, Y: 0}, {X: 1, Y: 2}, {X: 2, Y: 0}, {X: 0, Y: 2}}
+	tttc.Seq = len(tttc.Moves)
+	tttc.Status = ttt.StatusYourTurn
+	tttc.reviewRound()
+	assert.Nil(t, tttc.Review)
+
+	tttc.Status = ttt.StatusLoss
+	tttc.reviewRound()
+	assert.Equal(t, len(tttc.Review), 7)
+	assert.Equal(t, tttc.reviewLines(), "1. a1 best\n2. b2 best\n3. c3 best\n"+
+		"4. b1 best\n5. b3 best\n6. c1 missed block (a3)\n7. a3 best")
+
+	tttc.RoundID = "r1"
+	tttc.Mark = ttt.MarkO
+	r, err := tttc.Record()
+	assert.Nil(t, err)
+	assert.Equal(t, r.Comments, map[int]string{
+		5: "missed block: loss in 1, a3 draw"})
+
+	tttc.trackMove(&ttt.PlayerStatus{RoundID: "r2"})
+	assert.Nil(t, tttc.Review)
+	teardown()
+}
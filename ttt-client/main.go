@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"os/user"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/nsf/termbox-go"
@@ -19,16 +20,50 @@ func main() {
 	}
 	server := flag.String("s", "ws://localhost:8080", "server")
 	name := flag.String("u", username, "user name")
+	replay := flag.String("replay", "", "replay a game record file")
+	game := flag.String("game", "", "replay a game archived on the server")
+	speed := flag.Duration("speed", time.Second,
+		"time between moves of a replay, from "+MinReplaySpeed.String()+
+			" to "+MaxReplaySpeed.String())
+	level := flag.String("level", ttt.LevelExpert,
+		"level of the bots: beginner, intermediate or expert")
 	flag.Parse()
+	if !ttt.IsValidLevel(*level) {
+		glog.Exitln("Unknown AI level", *level)
+	}
+
+	if *replay != "" || *game != "" {
+		var r *ttt.Record
+		if *replay != "" {
+			r, err = LoadRecord(*replay)
+		} else {
+			r, err = FetchRecord(*server, *game)
+		}
+		if err != nil {
+			glog.Exitln("Can not load game:", err)
+		}
+		rp, err := NewReplay(r, *speed)
+		if err != nil {
+			glog.Exitln("Can not replay game:", err)
+		}
+		tttc := TTTCInit(*name)
+		defer termbox.Close()
+		tttc.RunReplay(rp)
+		return
+	}
 
 	tttc := TTTCInit(*name)
 	defer termbox.Close()
+	if *level != "" {
+		tttc.Level = *level
+	}
 
 	if err := tttc.Connect(*server); err != nil {
 		glog.Exitln("Can not connect to server.")
 	}
 
 	go tttc.Listener()
+	go tttc.Heartbeat()
 
 	tttc.RedrawAll()
 mainloop:
@@ -71,6 +106,12 @@ mainloop:
 				tttc.MoveCursor(ttt.Up)
 			case 'l':
 				tttc.MoveCursor(ttt.Right)
+			case 'e':
+				tttc.Export()
+			case 'd':
+				tttc.NextLevel()
+			case '?':
+				tttc.RequestHint()
 			}
 
 		case termbox.EventError:
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nsf/termbox-go"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	MinReplaySpeed = 100 * time.Millisecond
+	MaxReplaySpeed = 10 * time.Second
+)
+
+// A recorded game shown move by move
+type Replay struct {
+	Record *ttt.Record
+	// Number of moves shown
+	Step int
+	// Time between moves when playing
+	Speed   time.Duration
+	Playing bool
+}
+
+// A replay of a record at speed, kept between MinReplaySpeed and
+// MaxReplaySpeed
+func NewReplay(r *ttt.Record, speed time.Duration) (*Replay, error) {
+	if _, _, err := r.Grid(); err != nil {
+		return nil, err
+	}
+	if speed < MinReplaySpeed {
+		speed = MinReplaySpeed
+	} else if speed > MaxReplaySpeed {
+		speed = MaxReplaySpeed
+	}
+	return &Replay{Record: r, Speed: speed}, nil
+}
+
+// Read a record saved with the export key
+func LoadRecord(path string) (*ttt.Record, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ttt.ParseRecord(string(data))
+}
+
+// Fetch the record of an archived game from a server given by its
+// websocket address
+func FetchRecord(server, id string) (*ttt.Record, error) {
+	url := strings.TrimRight(server, "/")
+	if strings.HasPrefix(url, "ws") {
+		url = "http" + strings.TrimPrefix(url, "ws")
+	}
+	resp, err := http.Get(url + "/api/archive/" + id + ttt.RecordExt)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Can not fetch game " + id + ": " +
+			resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ttt.ParseRecord(string(data))
+}
+
+func (r *Replay) Forward() bool {
+	if r.Step >= len(r.Record.Moves) {
+		return false
+	}
+	r.Step++
+	return true
+}
+
+func (r *Replay) Backward() bool {
+	if r.Step == 0 {
+		return false
+	}
+	r.Step--
+	return true
+}
+
+func (r *Replay) Start() {
+	r.Step = 0
+}
+
+func (r *Replay) End() {
+	r.Step = len(r.Record.Moves)
+}
+
+// Play from the start when at the end
+func (r *Replay) TogglePlay() {
+	r.Playing = !r.Playing
+	if r.Playing && r.Step == len(r.Record.Moves) {
+		r.Start()
+	}
+}
+
+func (r *Replay) Faster() {
+	if r.Speed /= 2; r.Speed < MinReplaySpeed {
+		r.Speed = MinReplaySpeed
+	}
+}
+
+func (r *Replay) Slower() {
+	if r.Speed *= 2; r.Speed > MaxReplaySpeed {
+		r.Speed = MaxReplaySpeed
+	}
+}
+
+// The grid after the moves shown
+func (r *Replay) Grid() ttt.Grid {
+	var g ttt.Grid
+	m := ttt.MarkX
+	for _, p := range r.Record.Moves[:r.Step] {
+		g.Set(p, m)
+		m = m.Other()
+	}
+	return g
+}
+
+func (r *Replay) Status() string {
+	status := "Move " + strconv.Itoa(r.Step) + "/" +
+		strconv.Itoa(len(r.Record.Moves))
+	if r.Step == len(r.Record.Moves) && r.Record.Result != ttt.ResultUnknown {
+		status += " " + r.Record.Result
+	}
+	if r.Playing {
+		status += " playing every " + r.Speed.String()
+	}
+	return status
+}
+
+// Comment on the last move shown
+func (r *Replay) Comment() string {
+	if r.Step == 0 {
+		return ""
+	}
+	return r.Record.Comments[r.Step-1]
+}
+
+// Show the replay in place of a round, X being the player
+func (tttc *TTTClient) ShowReplay(r *Replay) {
+	tttc.Replay = r
+	tttc.Name = r.Record.X
+	tttc.VSName = r.Record.O
+	tttc.Mark = ttt.MarkX
+	tttc.VSMark = ttt.MarkO
+	tttc.RoundID = r.Record.Tags["Round"]
+	tttc.Grid = r.Grid()
+	tttc.Status = r.Status()
+	tttc.Notice = r.Comment()
+	if r.Step > 0 {
+		tttc.CursorPos = r.Record.Moves[r.Step-1]
+	}
+}
+
+// Show a replay until the user exits
+func (tttc *TTTClient) RunReplay(r *Replay) {
+	events := make(chan termbox.Event)
+	go func() {
+		for {
+			events <- termbox.PollEvent()
+		}
+	}()
+	for {
+		tttc.ShowReplay(r)
+		tttc.RedrawAll()
+		var tick <-chan time.Time
+		if r.Playing {
+			tick = time.After(r.Speed)
+		}
+		select {
+		case <-tick:
+			if !r.Forward() {
+				r.Playing = false
+			}
+		case ev := <-events:
+			if ev.Type == termbox.EventError {
+				glog.Fatal("Termbox EventError")
+			}
+			if ev.Type != termbox.EventKey {
+				continue
+			}
+			switch {
+			case ev.Key == termbox.KeyEsc || ev.Ch == 'q':
+				return
+			case ev.Key == termbox.KeyArrowLeft ||
+				ev.Key == termbox.KeyCtrlB || ev.Ch == 'h':
+				r.Playing = false
+				r.Backward()
+			case ev.Key == termbox.KeyArrowRight ||
+				ev.Key == termbox.KeyCtrlF || ev.Ch == 'l':
+				r.Playing = false
+				r.Forward()
+			case ev.Key == termbox.KeyArrowUp ||
+				ev.Key == termbox.KeyCtrlP || ev.Ch == 'k':
+				r.Playing = false
+				r.Start()
+			case ev.Key == termbox.KeyArrowDown ||
+				ev.Key == termbox.KeyCtrlN || ev.Ch == 'j':
+				r.Playing = false
+				r.End()
+			case ev.Key == termbox.KeyEnter || ev.Key == termbox.KeySpace:
+				r.TogglePlay()
+			case ev.Ch == '+':
+				r.Faster()
+			case ev.Ch == '-':
+				r.Slower()
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const replayRecord = `[X "Adam"]
+[O "Eve"]
+[Result "1-0"]
+
+1. b2 a1 2. c1 {threat} a3 3. a2 c3 4. b3 b1 5. c2 1-0
+`
+
+func replaySetup(t *testing.T) *Replay {
+	r, err := ttt.ParseRecord(replayRecord)
+	assert.Nil(t, err)
+	rp, err := NewReplay(r, time.Second)
+	assert.Nil(t, err)
+	return rp
+}
+
+func TestReplaySteps(t *testing.T) {
+	rp := replaySetup(t)
+	assert.False(t, rp.Backward())
+	assert.True(t, rp.Forward())
+	g := rp.Grid()
+	assert.Equal(t, g.Get(ttt.Position{X: 1, Y: 1}), ttt.MarkX)
+	assert.Equal(t, rp.Status(), "Move 1/9")
+
+	rp.End()
+	assert.False(t, rp.Forward())
+	assert.Equal(t, rp.Status(), "Move 9/9 1-0")
+	g = rp.Grid()
+	assert.True(t, g.IsFull())
+
+	rp.Start()
+	g = rp.Grid()
+	assert.True(t, g.IsEmpty())
+	assert.Equal(t, rp.Comment(), "")
+	rp.Step = 3
+	assert.Equal(t, rp.Comment(), "threat")
+}
+
+func TestReplayPlay(t *testing.T) {
+	rp := replaySetup(t)
+	rp.End()
+	rp.TogglePlay()
+	assert.True(t, rp.Playing)
+	assert.Equal(t, rp.Step, 0)
+	assert.Equal(t, rp.Status(), "Move 0/9 playing every 1s")
+
+	rp.Faster()
+	assert.Equal(t, rp.Speed, 500*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		rp.Faster()
+	}
+	assert.Equal(t, rp.Speed, MinReplaySpeed)
+	for i := 0; i < 10; i++ {
+		rp.Slower()
+	}
+	assert.Equal(t, rp.Speed, MaxReplaySpeed)
+}
+
+func TestNewReplaySpeed(t *testing.T) {
+	r := ttt.NewRecord("Adam", "Eve")
+	rp, err := NewReplay(r, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, rp.Speed, MinReplaySpeed)
+	rp, _ = NewReplay(r, time.Minute)
+	assert.Equal(t, rp.Speed, MaxReplaySpeed)
+	rp, _ = NewReplay(r, 2*time.Second)
+	assert.Equal(t, rp.Speed, 2*time.Second)
+}
+
+func TestNewReplayBadRecord(t *testing.T) {
+	r := ttt.NewRecord("Adam", "Eve")
+	r.Moves = []ttt.Position{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 0, Y: 1},
+		{X: 1, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}}
+	_, err := NewReplay(r, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestTTTCShowReplay(t *testing.T) {
+	setup()
+	rp := replaySetup(t)
+	rp.Step = 2
+	tttc.ShowReplay(rp)
+	assert.Equal(t, tttc.Name, "Adam")
+	assert.Equal(t, tttc.VSName, "Eve")
+	assert.Equal(t, tttc.CursorPos, ttt.Position{X: 0, Y: 0})
+	assert.Equal(t, tttc.Grid.Get(tttc.CursorPos), ttt.MarkO)
+	assert.Equal(t, tttc.markToRune(ttt.MarkO), ttt.OtherRune)
+	teardown()
+}
+
+func TestLoadRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ttt-replay")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "r1"+ttt.RecordExt)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(replayRecord), 0644))
+	r, err := LoadRecord(path)
+	assert.Nil(t, err)
+	assert.Equal(t, len(r.Moves), 9)
+	_, err = LoadRecord(filepath.Join(dir, "r2"+ttt.RecordExt))
+	assert.NotNil(t, err)
+}
+
+func TestFetchRecord(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/archive/r1.ttt" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(replayRecord))
+		}))
+	defer s.Close()
+	server := "ws" + s.URL[len("http"):]
+
+	r, err := FetchRecord(server, "r1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.X, "Adam")
+	_, err = FetchRecord(server, "r2")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	// Terminal size of casts, big enough for the board and the lines
+	// around it
+	CastWidth  = 40
+	CastHeight = 24
+
+	clearScreen = "\x1b[H\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+)
+
+// Characters on a terminal
+type screen [][]rune
+
+func newScreen(w, h int) screen {
+	s := make(screen, h)
+	for y := range s {
+		s[y] = []rune(strings.Repeat(" ", w))
+	}
+	return s
+}
+
+func (s screen) set(x, y int, r rune) {
+	if y >= 0 && y < len(s) && x >= 0 && x < len(s[y]) {
+		s[y][x] = r
+	}
+}
+
+func (s screen) print(x, y int, msg string) {
+	for _, r := range msg {
+		s.set(x, y, r)
+		x++
+	}
+}
+
+func (s screen) String() string {
+	lines := []string{}
+	for _, l := range s {
+		lines = append(lines, strings.TrimRight(string(l), " "))
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// Draw a frame the way ttt-client lays out a round
+func renderScreen(r *ttt.Record, f Frame) screen {
+	s := newScreen(CastWidth, CastHeight)
+	cx, cy := CastWidth/2, CastHeight/2
+	left := cx - ttt.Width/2
+	top := cy - ttt.Height/2
+	for yoffset := 0; yoffset <= ttt.Size; yoffset++ {
+		for xoffset := 0; xoffset <= ttt.Size; xoffset++ {
+			x := left + xoffset*ttt.XSpan
+			y := top + yoffset*ttt.YSpan
+			s.set(x, y, '+')
+			for i := 1; xoffset < ttt.Size && i < ttt.XSpan; i++ {
+				s.set(x+i, y, '-')
+			}
+			for i := 1; yoffset < ttt.Size && i < ttt.YSpan; i++ {
+				s.set(x, y+i, '|')
+			}
+		}
+	}
+	center := ttt.GetCenter()
+	for x, l := range f.Grid {
+		for y, m := range l {
+			if m != ttt.MarkEmpty {
+				s.print(cx-(center.X-x)*ttt.Width/ttt.Size,
+					cy-(center.Y-y)*ttt.Height/ttt.Size, m.String())
+			}
+		}
+	}
+	s.print(cx-len(ttt.Title)/2, top-2, ttt.Title)
+	s.print(left, top+ttt.Height+2, r.X+" (X) VS "+r.O+" (O)")
+	s.print(left, top+ttt.Height+4, f.Status)
+	return s
+}
+
+// Header of a cast, with no timestamp for games of no known date
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Title     string `json:"title"`
+}
+
+// Write an asciinema v2 cast showing a frame every delay
+func WriteCast(w io.Writer, r *ttt.Record, frames []Frame,
+	delay time.Duration) error {
+	enc := json.NewEncoder(w)
+	header := castHeader{
+		Version: 2,
+		Width:   CastWidth,
+		Height:  CastHeight,
+		Title:   r.X + " vs " + r.O,
+	}
+	if !r.Date.IsZero() {
+		header.Timestamp = r.Date.Unix()
+	}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	for i, f := range frames {
+		data := clearScreen + renderScreen(r, f).String()
+		if i == 0 {
+			data = hideCursor + data
+		}
+		t := (time.Duration(i) * delay).Seconds()
+		if err := enc.Encode([]interface{}{t, "o", data}); err != nil {
+			return err
+		}
+	}
+	// hold the last frame for as long as the others
+	t := (time.Duration(len(frames)) * delay).Seconds()
+	return enc.Encode([]interface{}{t, "o", showCursor})
+}
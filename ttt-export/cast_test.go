@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderScreen(t *testing.T) {
+	r, frames := sampleFrames(t)
+	lines := strings.Split(renderScreen(r, frames[2]).String(), "\r\n")
+	assert.Equal(t, len(lines), CastHeight)
+	assert.Equal(t, lines[4], "               Tic-tac-toe")
+	assert.Equal(t, lines[6], "     +---------+---------+---------+")
+	assert.Equal(t, lines[8], "     |    O    |         |         |")
+	assert.Equal(t, lines[12], "     |         |    X    |         |")
+	assert.Equal(t, lines[20], "     Adam (X) VS Eve (O)")
+	assert.Equal(t, lines[22], "     Move 2/9")
+}
+
+func TestWriteCast(t *testing.T) {
+	r, frames := sampleFrames(t)
+	var buffer bytes.Buffer
+	assert.Nil(t, WriteCast(&buffer, r, frames, 500*time.Millisecond))
+
+	scanner := bufio.NewScanner(&buffer)
+	assert.True(t, scanner.Scan())
+	header := castHeader{}
+	assert.Nil(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, header, castHeader{
+		Version:   2,
+		Width:     CastWidth,
+		Height:    CastHeight,
+		Timestamp: time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		Title:     "Adam vs Eve",
+	})
+
+	events := [][]interface{}{}
+	for scanner.Scan() {
+		e := []interface{}{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	assert.Equal(t, len(events), len(frames)+1)
+	assert.Equal(t, events[3][0], 1.5)
+	assert.Equal(t, events[3][1], "o")
+	assert.True(t, strings.HasPrefix(events[3][2].(string), clearScreen))
+	assert.Equal(t, events[10], []interface{}{5.0, "o", showCursor})
+
+	// a game of no known date has no timestamp
+	r.Date = time.Time{}
+	buffer.Reset()
+	assert.Nil(t, WriteCast(&buffer, r, frames, 500*time.Millisecond))
+	line, _ := buffer.ReadString('\n')
+	assert.False(t, strings.Contains(line, "timestamp"))
+}
@@ -0,0 +1,2 @@
+// Turn tic-tac-toe game records into asciinema casts and animated GIFs
+package main
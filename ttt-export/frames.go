@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/wujiang/tic-tac-toe"
+)
+
+// The board after a move of a game, or before the first one
+type Frame struct {
+	Grid   ttt.Grid
+	Status string
+	// The last move, nil before the first one
+	Move *ttt.Position
+}
+
+// A frame for the empty board and one for each move
+func Frames(r *ttt.Record) ([]Frame, error) {
+	if _, _, err := r.Grid(); err != nil {
+		return nil, err
+	}
+	total := strconv.Itoa(len(r.Moves))
+	frames := []Frame{{Status: "Move 0/" + total}}
+	var g ttt.Grid
+	m := ttt.MarkX
+	for i := range r.Moves {
+		p := r.Moves[i]
+		g.Set(p, m)
+		m = m.Other()
+		f := Frame{
+			Grid:   g,
+			Status: "Move " + strconv.Itoa(i+1) + "/" + total,
+			Move:   &p,
+		}
+		if i == len(r.Moves)-1 && r.Result != ttt.ResultUnknown {
+			f.Status += " " + r.Result
+		}
+		if c := r.Comments[i]; c != "" {
+			f.Status += " - " + c
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
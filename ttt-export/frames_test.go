@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const sampleRecord = `[X "Adam"]
+[O "Eve"]
+[Date "2015.06.01"]
+[Result "1-0"]
+
+1. b2 a1 2. c1 a3 3. a2 c3 4. b3 {forced} b1 5. c2 1-0
+`
+
+func sampleFrames(t *testing.T) (*ttt.Record, []Frame) {
+	r, err := ttt.ParseRecord(sampleRecord)
+	assert.Nil(t, err)
+	frames, err := Frames(r)
+	assert.Nil(t, err)
+	return r, frames
+}
+
+func TestFrames(t *testing.T) {
+	_, frames := sampleFrames(t)
+	assert.Equal(t, len(frames), 10)
+	assert.Equal(t, frames[0].Status, "Move 0/9")
+	assert.Nil(t, frames[0].Move)
+	assert.True(t, frames[0].Grid.IsEmpty())
+	assert.Equal(t, *frames[2].Move, ttt.Position{X: 0, Y: 0})
+	assert.Equal(t, frames[2].Grid.Get(ttt.Position{X: 0, Y: 0}), ttt.MarkO)
+	assert.Equal(t, frames[7].Status, "Move 7/9 - forced")
+	assert.Equal(t, frames[9].Status, "Move 9/9 1-0")
+	assert.True(t, frames[9].Grid.IsFull())
+}
+
+func TestFramesBadRecord(t *testing.T) {
+	r := ttt.NewRecord("Adam", "Eve")
+	r.Width = 4
+	_, err := Frames(r)
+	assert.NotNil(t, err)
+}
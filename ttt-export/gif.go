@@ -0,0 +1,130 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"time"
+
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	// Size of a cell in pixels and of the border around the board
+	CellPixels   = 80
+	BorderPixels = 10
+
+	lineWidth   = 4
+	markPadding = 16
+)
+
+const (
+	colorBackground uint8 = iota
+	colorLine
+	colorX
+	colorO
+	colorLastMove
+)
+
+var gifPalette = color.Palette{
+	color.White,
+	color.Black,
+	color.RGBA{0xcc, 0x22, 0x22, 0xff},
+	color.RGBA{0x22, 0x44, 0xcc, 0xff},
+	color.RGBA{0xff, 0xf2, 0xa8, 0xff},
+}
+
+// The top left pixel of a cell
+func cellOrigin(p ttt.Position) image.Point {
+	return image.Pt(BorderPixels+p.X*CellPixels, BorderPixels+p.Y*CellPixels)
+}
+
+func fillRect(img *image.Paletted, r image.Rectangle, c uint8) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetColorIndex(x, y, c)
+		}
+	}
+}
+
+// A thick line from a to b
+func drawLine(img *image.Paletted, a, b image.Point, c uint8) {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	steps := int(math.Max(math.Abs(dx), math.Abs(dy)))
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := a.X + int(math.Round(dx*t))
+		y := a.Y + int(math.Round(dy*t))
+		fillRect(img, image.Rect(x-lineWidth/2, y-lineWidth/2,
+			x+lineWidth/2, y+lineWidth/2), c)
+	}
+}
+
+func drawX(img *image.Paletted, p ttt.Position) {
+	o := cellOrigin(p)
+	lo, hi := markPadding, CellPixels-markPadding
+	drawLine(img, o.Add(image.Pt(lo, lo)), o.Add(image.Pt(hi, hi)), colorX)
+	drawLine(img, o.Add(image.Pt(hi, lo)), o.Add(image.Pt(lo, hi)), colorX)
+}
+
+func drawO(img *image.Paletted, p ttt.Position) {
+	o := cellOrigin(p)
+	c := float64(CellPixels) / 2
+	radius := c - markPadding
+	for y := 0; y < CellPixels; y++ {
+		for x := 0; x < CellPixels; x++ {
+			d := math.Hypot(float64(x)+0.5-c, float64(y)+0.5-c)
+			if math.Abs(d-radius) <= lineWidth/2 {
+				img.SetColorIndex(o.X+x, o.Y+y, colorO)
+			}
+		}
+	}
+}
+
+// Draw the board of a frame
+func renderImage(f Frame) *image.Paletted {
+	side := 2*BorderPixels + ttt.Size*CellPixels
+	img := image.NewPaletted(image.Rect(0, 0, side, side), gifPalette)
+	if f.Move != nil {
+		o := cellOrigin(*f.Move)
+		fillRect(img, image.Rect(o.X, o.Y, o.X+CellPixels, o.Y+CellPixels),
+			colorLastMove)
+	}
+	for i := 1; i < ttt.Size; i++ {
+		at := BorderPixels + i*CellPixels
+		fillRect(img, image.Rect(at-lineWidth/2, BorderPixels,
+			at+lineWidth/2, side-BorderPixels), colorLine)
+		fillRect(img, image.Rect(BorderPixels, at-lineWidth/2,
+			side-BorderPixels, at+lineWidth/2), colorLine)
+	}
+	for x, l := range f.Grid {
+		for y, m := range l {
+			switch m {
+			case ttt.MarkX:
+				drawX(img, ttt.Position{X: x, Y: y})
+			case ttt.MarkO:
+				drawO(img, ttt.Position{X: x, Y: y})
+			}
+		}
+	}
+	return img
+}
+
+// Write an animated GIF showing a frame every delay and holding the
+// last one three times as long
+func WriteGIF(w io.Writer, frames []Frame, delay time.Duration) error {
+	anim := &gif.GIF{}
+	centis := int(delay / (10 * time.Millisecond))
+	for i, f := range frames {
+		anim.Image = append(anim.Image, renderImage(f))
+		if i == len(frames)-1 {
+			anim.Delay = append(anim.Delay, 3*centis)
+		} else {
+			anim.Delay = append(anim.Delay, centis)
+		}
+	}
+	return gif.EncodeAll(w, anim)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+// The color in the middle of a cell
+func cellCenter(img *image.Paletted, p ttt.Position) uint8 {
+	o := cellOrigin(p)
+	return img.ColorIndexAt(o.X+CellPixels/2, o.Y+CellPixels/2)
+}
+
+func TestRenderImage(t *testing.T) {
+	_, frames := sampleFrames(t)
+	img := renderImage(frames[2])
+	side := 2*BorderPixels + ttt.Size*CellPixels
+	assert.Equal(t, img.Bounds(), image.Rect(0, 0, side, side))
+	// X crosses in the middle, O does not
+	assert.Equal(t, cellCenter(img, ttt.Position{X: 1, Y: 1}), colorX)
+	assert.Equal(t, cellCenter(img, ttt.Position{X: 0, Y: 0}),
+		colorLastMove)
+	assert.Equal(t, cellCenter(img, ttt.Position{X: 2, Y: 2}),
+		colorBackground)
+	o := cellOrigin(ttt.Position{X: 0, Y: 0})
+	assert.Equal(t, img.ColorIndexAt(o.X+CellPixels/2, o.Y+markPadding),
+		colorO)
+	// grid lines
+	assert.Equal(t, img.ColorIndexAt(BorderPixels+CellPixels, side/2+30),
+		colorLine)
+	assert.Equal(t, img.ColorIndexAt(0, 0), colorBackground)
+}
+
+func TestWriteGIF(t *testing.T) {
+	_, frames := sampleFrames(t)
+	var buffer bytes.Buffer
+	assert.Nil(t, WriteGIF(&buffer, frames, 500*time.Millisecond))
+	anim, err := gif.DecodeAll(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, len(anim.Image), len(frames))
+	assert.Equal(t, anim.Delay[0], 50)
+	assert.Equal(t, anim.Delay[len(frames)-1], 150)
+}
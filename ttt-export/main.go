@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+// Usage: ttt-export -o game.gif game.ttt
+func main() {
+	out := flag.String("o", "", "output file, a .cast or a .gif")
+	delay := flag.Duration("delay", time.Second, "time between moves")
+	flag.Parse()
+	if flag.NArg() != 1 || *out == "" {
+		fmt.Fprintln(os.Stderr, "usage: ttt-export -o out.cast|out.gif game"+
+			ttt.RecordExt)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		glog.Exitln(err)
+	}
+	r, err := ttt.ParseRecord(string(data))
+	if err != nil {
+		glog.Exitln("Can not read game:", err)
+	}
+	frames, err := Frames(r)
+	if err != nil {
+		glog.Exitln("Can not replay game:", err)
+	}
+
+	var write func(io.Writer) error
+	switch filepath.Ext(*out) {
+	case ".cast":
+		write = func(w io.Writer) error {
+			return WriteCast(w, r, frames, *delay)
+		}
+	case ".gif":
+		write = func(w io.Writer) error {
+			return WriteGIF(w, frames, *delay)
+		}
+	default:
+		glog.Exitln("Unknown output format", *out)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		glog.Exitln(err)
+	}
+	if err := f.Close(); err != nil {
+		glog.Exitln(err)
+	}
+}
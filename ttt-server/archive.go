@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	EventCreated string = "created"
+	EventMove    string = "move"
+	EventChat    string = "chat"
+	EventHint    string = "hint"
+	EventReview  string = "review"
+	EventResult  string = "result"
+
+	ResultWin     string = "win"
+	ResultTie     string = "tie"
+	ResultLeft    string = "left"
+	ResultAborted string = "aborted"
+)
+
+var (
+	errNoReview    = errors.New("Game has not been reviewed")
+	errGameNotOver = errors.New("Game is not over yet")
+)
+
+type ArchivedPlayer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Something that happened in a round. Which fields are set depends on
+// the type: players for created, the move for move, the text for chat,
+// the result for result and the annotated moves for review. PlayerID
+// is who made the move, chatted, asked for a hint or left.
+type RoundEvent struct {
+	Type     string             `json:"type"`
+	Time     time.Time          `json:"time"`
+	RoundID  string             `json:"round_id"`
+	PlayerID string             `json:"player_id,omitempty"`
+	X        *ArchivedPlayer    `json:"x,omitempty"`
+	O        *ArchivedPlayer    `json:"o,omitempty"`
+	Move     *ttt.MoveEvent     `json:"move,omitempty"`
+	Text     string             `json:"text,omitempty"`
+	Result   string             `json:"result,omitempty"`
+	Winner   ttt.Mark           `json:"winner,omitempty"`
+	Review   []ttt.ReviewedMove `json:"review,omitempty"`
+}
+
+// What a game comes down to
+type GameSummary struct {
+	ID      string         `json:"id"`
+	X       ArchivedPlayer `json:"x"`
+	O       ArchivedPlayer `json:"o"`
+	Started time.Time      `json:"started"`
+	Ended   time.Time      `json:"ended,omitempty"`
+	Moves   int            `json:"moves"`
+	Result  string         `json:"result,omitempty"`
+	Winner  ttt.Mark       `json:"winner,omitempty"`
+	// Hints asked for by each player
+	HintsX int `json:"hints_x,omitempty"`
+	HintsO int `json:"hints_o,omitempty"`
+	// Whether the moves were reviewed
+	Reviewed bool `json:"reviewed,omitempty"`
+	// Zobrist hash of the last position, the same for all games which
+	// got there
+	Hash uint64 `json:"hash,omitempty,string"`
+}
+
+func (s *GameSummary) hasPlayer(player string) bool {
+	return s.X.ID == player || s.X.Name == player ||
+		s.O.ID == player || s.O.Name == player
+}
+
+// Fold an event into the summary
+func (s *GameSummary) apply(e *RoundEvent) {
+	switch e.Type {
+	case EventCreated:
+		s.ID = e.RoundID
+		s.Started = e.Time
+		if e.X != nil {
+			s.X = *e.X
+		}
+		if e.O != nil {
+			s.O = *e.O
+		}
+	case EventMove:
+		s.Moves++
+		if e.Move != nil {
+			s.Hash ^= ttt.StandardZobrist.Move(e.Move.Pos, e.Move.Mark)
+		}
+	case EventHint:
+		if e.PlayerID == s.X.ID {
+			s.HintsX++
+		} else if e.PlayerID == s.O.ID {
+			s.HintsO++
+		}
+	case EventReview:
+		s.Reviewed = true
+	case EventResult:
+		s.Ended = e.Time
+		s.Result = e.Result
+		s.Winner = e.Winner
+	}
+}
+
+// A game with all of its events, oldest first
+type GameRecord struct {
+	GameSummary
+	Events []RoundEvent `json:"events"`
+}
+
+// The latest review of the moves, nil if there is none
+func (r *GameRecord) Review() []ttt.ReviewedMove {
+	var review []ttt.ReviewedMove
+	for _, e := range r.Events {
+		if e.Type == EventReview {
+			review = e.Review
+		}
+	}
+	return review
+}
+
+// The game in the portable record format, annotated if it was reviewed.
+// Games that did not end in a win or a tie get the unknown result and a
+// Termination tag.
+func (r *GameRecord) TTTRecord() *ttt.Record {
+	record := ttt.NewRecord(r.X.Name, r.O.Name)
+	record.Date = r.Started
+	record.Tags["Round"] = r.ID
+	switch r.Result {
+	case ResultWin:
+		record.Result = ttt.ResultOf(r.Winner)
+	case ResultTie:
+		record.Result = ttt.ResultDraw
+	case "":
+	default:
+		record.Tags["Termination"] = r.Result
+	}
+	for _, e := range r.Events {
+		if e.Type == EventMove && e.Move != nil {
+			record.Moves = append(record.Moves, e.Move.Pos)
+		}
+	}
+	record.Annotate(r.Review())
+	return record
+}
+
+// Sorted with the most recently started first
+type GameSummaries []*GameSummary
+
+func (gs GameSummaries) Len() int           { return len(gs) }
+func (gs GameSummaries) Swap(i, j int)      { gs[i], gs[j] = gs[j], gs[i] }
+func (gs GameSummaries) Less(i, j int) bool { return gs[i].Started.After(gs[j].Started) }
+
+// Keep each round as an append-only log of events, one JSON event per
+// line in Dir/{round id}.jsonl, and serve them under /api/archive
+type Archive struct {
+	Dir       string
+	summaries map[string]*GameSummary
+	lock      sync.Mutex
+}
+
+// Create an archive and index the games logged in dir
+func NewArchive(dir string) (*Archive, error) {
+	a := &Archive{
+		Dir:       dir,
+		summaries: make(map[string]*GameSummary),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		r, err := readRecord(f)
+		if err != nil {
+			glog.Warningln("skip broken round log", f, err)
+			continue
+		}
+		a.summaries[r.ID] = &r.GameSummary
+	}
+	glog.Infoln("indexed", len(a.summaries), "archived games")
+	return a, nil
+}
+
+func (a *Archive) path(id string) string {
+	return filepath.Join(a.Dir, id+".jsonl")
+}
+
+func readRecord(path string) (*GameRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &GameRecord{Events: []RoundEvent{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := RoundEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		r.apply(&e)
+		r.Events = append(r.Events, e)
+	}
+	return r, scanner.Err()
+}
+
+// Append an event to the log of its round
+func (a *Archive) Append(e *RoundEvent) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.write(e)
+}
+
+func (a *Archive) write(e *RoundEvent) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path(e.RoundID),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s := a.summaries[e.RoundID]
+	if s == nil {
+		s = &GameSummary{ID: e.RoundID}
+		a.summaries[e.RoundID] = s
+	}
+	s.apply(e)
+	return nil
+}
+
+// The full record of a game
+func (a *Archive) Record(id string) (*GameRecord, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.summaries[id] == nil {
+		return nil, errNoSuchGame
+	}
+	return readRecord(a.path(id))
+}
+
+// Review the moves of a game which is over and log the review with it,
+// unless it was reviewed before
+func (a *Archive) StoreReview(id string) ([]ttt.ReviewedMove, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.summaries[id] == nil {
+		return nil, errNoSuchGame
+	}
+	r, err := readRecord(a.path(id))
+	if err != nil {
+		return nil, err
+	} else if r.Reviewed {
+		return r.Review(), nil
+	} else if r.Result == "" {
+		return nil, errGameNotOver
+	}
+	review, err := r.TTTRecord().Review()
+	if err != nil {
+		return nil, err
+	}
+	err = a.write(&RoundEvent{
+		Type:    EventReview,
+		RoundID: id,
+		Review:  review,
+	})
+	return review, err
+}
+
+// Games of a player, given by ID or name, most recent first
+func (a *Archive) List(player string) GameSummaries {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	games := GameSummaries{}
+	for _, s := range a.summaries {
+		if player == "" || s.hasPlayer(player) {
+			summary := *s
+			games = append(games, &summary)
+		}
+	}
+	sort.Sort(games)
+	return games
+}
+
+// Routes:
+//
+//	GET /api/archive?player=p     games of a player, by ID or name
+//	GET /api/archive/{id}         a game with all of its events
+//	GET /api/archive/{id}.ttt     a game in the record format
+//	GET /api/archive/{id}/review  the annotated moves of a game
+//	POST /api/archive/{id}/review review a game and store the annotations
+func (a *Archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/archive"), "/")
+	if strings.HasSuffix(id, "/review") {
+		a.serveReview(w, r, strings.TrimSuffix(id, "/review"))
+		return
+	}
+	switch {
+	case r.Method != "GET" || strings.Contains(id, "/"):
+		http.NotFound(w, r)
+	case id == "":
+		writeJSON(w, http.StatusOK, a.List(r.URL.Query().Get("player")))
+	case strings.HasSuffix(id, ttt.RecordExt):
+		record, err := a.Record(strings.TrimSuffix(id, ttt.RecordExt))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(record.TTTRecord().String()))
+	default:
+		record, err := a.Record(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+
+func (a *Archive) serveReview(w http.ResponseWriter, r *http.Request, id string) {
+	switch {
+	case strings.Contains(id, "/"):
+		http.NotFound(w, r)
+	case r.Method == "GET":
+		record, err := a.Record(id)
+		if err == nil && record.Review() == nil {
+			err = errNoReview
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, record.Review())
+	case r.Method == "POST":
+		review, err := a.StoreReview(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, review)
+	default:
+		http.NotFound(w, r)
+	}
+}
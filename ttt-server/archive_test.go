@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+func archiveSetup(t *testing.T) (*Archive, string) {
+	dir, err := ioutil.TempDir("", "ttt-archive")
+	assert.Nil(t, err)
+	a, err := NewArchive(dir)
+	assert.Nil(t, err)
+	return a, dir
+}
+
+func archiveGame(a *Archive, id string, started time.Time) {
+	a.Append(&RoundEvent{
+		Type:    EventCreated,
+		Time:    started,
+		RoundID: id,
+		X:       &ArchivedPlayer{ID: "p1", Name: "Adam"},
+		O:       &ArchivedPlayer{ID: "p2", Name: "Eve"},
+	})
+	a.Append(&RoundEvent{
+		Type:     EventMove,
+		RoundID:  id,
+		PlayerID: "p1",
+		Move:     &ttt.MoveEvent{Seq: 1, Pos: ttt.Position{X: 1, Y: 1}, Mark: ttt.MarkX},
+	})
+	a.Append(&RoundEvent{
+		Type:     EventChat,
+		RoundID:  id,
+		PlayerID: "p2",
+		Text:     "hi",
+	})
+	a.Append(&RoundEvent{
+		Type:     EventResult,
+		RoundID:  id,
+		PlayerID: "p2",
+		Result:   ResultLeft,
+	})
+}
+
+func TestArchiveRecord(t *testing.T) {
+	a, dir := archiveSetup(t)
+	defer os.RemoveAll(dir)
+	started := time.Now().Add(-time.Minute).Round(time.Second)
+	archiveGame(a, "r1", started)
+
+	r, err := a.Record("r1")
+	assert.Nil(t, err)
+	assert.Equal(t, len(r.Events), 4)
+	assert.Equal(t, r.ID, "r1")
+	assert.Equal(t, r.X, ArchivedPlayer{ID: "p1", Name: "Adam"})
+	assert.Equal(t, r.O, ArchivedPlayer{ID: "p2", Name: "Eve"})
+	assert.True(t, r.Started.Equal(started))
+	assert.Equal(t, r.Moves, 1)
+	assert.Equal(t, r.Result, ResultLeft)
+	assert.Equal(t, r.Events[2].Text, "hi")
+	assert.Equal(t, *r.Events[1].Move, ttt.MoveEvent{Seq: 1,
+		Pos: ttt.Position{X: 1, Y: 1}, Mark: ttt.MarkX})
+
+	_, err = a.Record("r2")
+	assert.Equal(t, err, errNoSuchGame)
+
+	// the logs are indexed again on startup
+	a, err = NewArchive(dir)
+	assert.Nil(t, err)
+	games := a.List("Adam")
+	assert.Equal(t, len(games), 1)
+	assert.Equal(t, *games[0], r.GameSummary)
+}
+
+func TestArchiveList(t *testing.T) {
+	a, dir := archiveSetup(t)
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	archiveGame(a, "old", now.Add(-time.Hour))
+	archiveGame(a, "new", now)
+	a.Append(&RoundEvent{
+		Type:    EventCreated,
+		RoundID: "other",
+		X:       &ArchivedPlayer{ID: "p3", Name: "Bob"},
+		O:       &ArchivedPlayer{ID: "p4", Name: "Pikachu"},
+	})
+
+	games := a.List("p2")
+	assert.Equal(t, len(games), 2)
+	assert.Equal(t, games[0].ID, "new")
+	assert.Equal(t, games[1].ID, "old")
+	assert.Equal(t, len(a.List("Pikachu")), 1)
+	assert.Equal(t, len(a.List("nobody")), 0)
+	assert.Equal(t, len(a.List("")), 3)
+}
+
+func TestArchiveServeHTTP(t *testing.T) {
+	a, dir := archiveSetup(t)
+	defer os.RemoveAll(dir)
+	archiveGame(a, "r1", time.Now())
+	s := httptest.NewServer(a)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/api/archive?player=Eve")
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	games := GameSummaries{}
+	json.NewDecoder(resp.Body).Decode(&games)
+	resp.Body.Close()
+	assert.Equal(t, len(games), 1)
+	assert.Equal(t, games[0].ID, "r1")
+
+	resp, err = http.Get(s.URL + "/api/archive/r1")
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	r := GameRecord{}
+	json.NewDecoder(resp.Body).Decode(&r)
+	resp.Body.Close()
+	assert.Equal(t, len(r.Events), 4)
+
+	resp, err = http.Get(s.URL + "/api/archive/r1.ttt")
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	record, err := ttt.ParseRecord(string(body))
+	assert.Nil(t, err)
+	assert.Equal(t, record.Tags["Round"], "r1")
+
+	resp, err = http.Get(s.URL + "/api/archive/r2")
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+	resp.Body.Close()
+}
+
+func TestArchiveReview(t *testing.T) {
+	a, dir := archiveSetup(t)
+	defer os.RemoveAll(dir)
+	archiveGame(a, "r1", time.Now())
+	// O answers the center with a side
+	a.Append(&RoundEvent{
+		Type:     EventMove,
+		RoundID:  "r1",
+		PlayerID: "p2",
+		Move:     &ttt.MoveEvent{Seq: 2, Pos: ttt.Position{X: 1, Y: 0}, Mark: ttt.MarkO},
+	})
+	s := httptest.NewServer(a)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/api/archive/r1/review")
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+	resp.Body.Close()
+
+	resp, err = http.Post(s.URL+"/api/archive/r1/review", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	review := []ttt.ReviewedMove{}
+	json.NewDecoder(resp.Body).Decode(&review)
+	resp.Body.Close()
+	assert.Equal(t, len(review), 2)
+	assert.Equal(t, review[0].Class, ttt.ClassBest)
+	assert.Equal(t, review[1].Class, ttt.ClassBlunder)
+
+	resp, err = http.Get(s.URL + "/api/archive/r1/review")
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	resp.Body.Close()
+	assert.True(t, a.List("")[0].Reviewed)
+
+	r, err := a.Record("r1")
+	assert.Nil(t, err)
+	assert.Equal(t, r.Review(), review)
+	assert.Equal(t, r.TTTRecord().Comments, map[int]string{
+		1: "blunder: loss in 3, a1 draw"})
+
+	// a game is reviewed once
+	resp, err = http.Post(s.URL+"/api/archive/r1/review", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	resp.Body.Close()
+	again, err := a.Record("r1")
+	assert.Nil(t, err)
+	assert.Equal(t, len(again.Events), len(r.Events))
+
+	resp, err = http.Post(s.URL+"/api/archive/r2/review", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+	resp.Body.Close()
+
+	// games going on are not reviewed
+	a.Append(&RoundEvent{Type: EventCreated, RoundID: "r3"})
+	resp, err = http.Post(s.URL+"/api/archive/r3/review", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusConflict)
+	resp.Body.Close()
+	r, err = a.Record("r3")
+	assert.Nil(t, err)
+	assert.False(t, r.Reviewed)
+}
+
+func TestGameRecordTTTRecord(t *testing.T) {
+	a, dir := archiveSetup(t)
+	defer os.RemoveAll(dir)
+	started := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	archiveGame(a, "r1", started)
+	r, err := a.Record("r1")
+	assert.Nil(t, err)
+
+	record := r.TTTRecord()
+	assert.Equal(t, record.X, "Adam")
+	assert.Equal(t, record.O, "Eve")
+	assert.Equal(t, record.Date, started)
+	assert.Equal(t, record.Moves, []ttt.Position{{X: 1, Y: 1}})
+	assert.Equal(t, record.Result, ttt.ResultUnknown)
+	assert.Equal(t, record.Tags, map[string]string{"Round": "r1",
+		"Termination": ResultLeft})
+
+	r.Result = ResultWin
+	r.Winner = ttt.MarkO
+	assert.Equal(t, r.TTTRecord().Result, ttt.ResultOWins)
+	r.Result = ResultTie
+	assert.Equal(t, r.TTTRecord().Result, ttt.ResultDraw)
+}
+
+func TestTTTSRecordRound(t *testing.T) {
+	a, dir := archiveSetup(t)
+	defer os.RemoveAll(dir)
+	ttts.Archive = a
+	ttts.RatedHints = ttt.HintsServer
+	player1 := &Player{ID: "player-1", Name: "Adam"}
+	player2 := &Player{ID: "player-2", Name: "John"}
+	rd := ttts.createNewRound(player1, player2)
+	<-ttts.Announce
+	<-ttts.Announce
+
+	assert.Equal(t, ttts.ProcessChat(&ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: player1.ID,
+		Text:     "good luck ",
+		Cmd:      ttt.CmdChat,
+	}), "")
+	chat := <-ttts.Announce
+	assert.Equal(t, chat.ToPlayer.ID, player2.ID)
+	assert.Equal(t, chat.Notice, "Adam: good luck")
+	assert.Equal(t, ttts.ProcessChat(&ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: player1.ID,
+		Cmd:      ttt.CmdChat,
+	}), ttt.ReasonEmptyText)
+	// long messages are cut on a character
+	assert.Equal(t, ttts.ProcessChat(&ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: player1.ID,
+		Text:     strings.Repeat("é", ttt.ChatLengthLimit+1),
+		Cmd:      ttt.CmdChat,
+	}), "")
+	chat = <-ttts.Announce
+	assert.Equal(t, chat.Notice,
+		"Adam: "+strings.Repeat("é", ttt.ChatLengthLimit))
+	assert.Equal(t, ttts.ProcessHint(&ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: rd.XPlayer.ID,
+		Cmd:      ttt.CmdHint,
+	}), "")
+	<-ttts.Announce
+
+	// X wins on the diagonal
+	moves := []ttt.Position{{X: 0, Y: 0}, {X: 0, Y: 1}, {X: 1, Y: 1},
+		{X: 0, Y: 2}, {X: 2, Y: 2}}
+	for _, pos := range moves {
+		rd = (*ttts.Groups)[rd.ID]
+		ttts.Judge(&ttt.PlayerAction{
+			RoundID:  rd.ID,
+			PlayerID: rd.CurrentPlayer.ID,
+			Pos:      pos,
+			Cmd:      ttt.CmdMove,
+		})
+		<-ttts.Announce
+		<-ttts.Announce
+	}
+
+	r, err := a.Record(rd.ID)
+	assert.Nil(t, err)
+	types := []string{}
+	for _, e := range r.Events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, types, []string{EventCreated, EventChat, EventChat,
+		EventHint, EventMove, EventMove, EventMove, EventMove, EventMove,
+		EventResult})
+	assert.Equal(t, r.Moves, 5)
+	assert.Equal(t, r.Hash, rd.Grid.Hash(ttt.MarkO))
+	assert.Equal(t, r.Result, ResultWin)
+	assert.Equal(t, r.Winner, ttt.MarkX)
+	assert.Equal(t, r.X.ID, rd.XPlayer.ID)
+	assert.Equal(t, r.HintsX, 1)
+	assert.Equal(t, r.HintsO, 0)
+	ttts.Archive = nil
+	ttts.RatedHints = ttt.HintsOff
+	tttsTeardown()
+}
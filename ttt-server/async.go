@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+
+	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	AsyncOpen    string = "open"
+	AsyncPlaying string = "playing"
+	AsyncOver    string = "over"
+
+	// Longest time a request waits for a game to change
+	LongPollTimeout = 30 * time.Second
+)
+
+var (
+	errNoSuchGame     = errors.New("No such game")
+	errNotInGame      = errors.New("Not a player of this game")
+	errNotOpen        = errors.New("Game is not open")
+	errStaleVersion   = errors.New("Game has changed, fetch it again")
+	errNoPlayer       = errors.New("Player is missing")
+	errAlreadyInGame  = errors.New("Already a player of this game")
+	errGameNotPlaying = errors.New("Game is not being played")
+)
+
+// A turn based game played over HTTP, possibly over days. Version is
+// bumped on every change and must be sent along with moves, as well as
+// the token of the seat handed out when creating or joining the game.
+type AsyncGame struct {
+	ID      string         `json:"id"`
+	Version int            `json:"version"`
+	X       string         `json:"x"`
+	O       string         `json:"o,omitempty"`
+	Status  string         `json:"status"`
+	Winner  ttt.Mark       `json:"winner,omitempty"`
+	Grid    ttt.Grid       `json:"grid"`
+	Moves   []ttt.Position `json:"moves"`
+	Created time.Time      `json:"created"`
+	Updated time.Time      `json:"updated"`
+	// Secrets of the seats, saved with the game but never shown
+	XToken string `json:"x_token,omitempty"`
+	OToken string `json:"o_token,omitempty"`
+}
+
+// The game without the tokens of its seats
+func (g *AsyncGame) public() *AsyncGame {
+	pg := *g
+	pg.XToken = ""
+	pg.OToken = ""
+	return &pg
+}
+
+// Whether the token is the one of the seat of the mark
+func (g *AsyncGame) holds(mark ttt.Mark, token string) bool {
+	seat := g.XToken
+	if mark == ttt.MarkO {
+		seat = g.OToken
+	}
+	return seat != "" &&
+		subtle.ConstantTimeCompare([]byte(seat), []byte(token)) == 1
+}
+
+// Mark of the player to move next
+func (g *AsyncGame) Turn() ttt.Mark {
+	if len(g.Moves)%2 == 0 {
+		return ttt.MarkX
+	}
+	return ttt.MarkO
+}
+
+func (g *AsyncGame) markOf(player string) ttt.Mark {
+	if player == "" {
+		return ttt.MarkEmpty
+	} else if player == g.X {
+		return ttt.MarkX
+	} else if player == g.O {
+		return ttt.MarkO
+	}
+	return ttt.MarkEmpty
+}
+
+// Play a move for a player, checking the token of the seat, turns and
+// the version the player has seen
+func (g *AsyncGame) play(player, token string, pos ttt.Position,
+	version int) error {
+	mark := g.markOf(player)
+	if mark == ttt.MarkEmpty || !g.holds(mark, token) {
+		return errNotInGame
+	} else if g.Status != AsyncPlaying {
+		return errGameNotPlaying
+	} else if version != g.Version {
+		return errStaleVersion
+	} else if mark != g.Turn() {
+		return errors.New(ttt.ReasonNotYourTurn)
+	} else if !ttt.IsValidPosition(pos) {
+		return errors.New(ttt.ReasonInvalidPosition)
+	} else if g.Grid.Get(pos) != ttt.MarkEmpty {
+		return errors.New(ttt.ReasonCellTaken)
+	}
+	g.Moves = append(g.Moves, pos)
+	if g.Grid.HasSameMarksInRows(pos, mark) {
+		g.Status = AsyncOver
+		g.Winner = mark
+	} else if g.Grid.IsFull() {
+		g.Status = AsyncOver
+	}
+	return nil
+}
+
+// Serve asynchronous games under /api/games and keep each of them in
+// a JSON file in Dir
+type AsyncServer struct {
+	Dir     string
+	games   map[string]*AsyncGame
+	changed map[string]chan bool
+	lock    sync.Mutex
+}
+
+// Create an async server and load the games saved in dir
+func NewAsyncServer(dir string) (*AsyncServer, error) {
+	as := &AsyncServer{
+		Dir:     dir,
+		games:   make(map[string]*AsyncGame),
+		changed: make(map[string]chan bool),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		g := &AsyncGame{}
+		if err := json.Unmarshal(data, g); err != nil {
+			glog.Warningln("skip broken game file", f, err)
+			continue
+		}
+		as.games[g.ID] = g
+	}
+	glog.Infoln("loaded", len(as.games), "async games")
+	return as, nil
+}
+
+// Write a game to disk. The caller holds the lock.
+func (as *AsyncServer) save(g *AsyncGame) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(as.Dir, g.ID+".json")
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Bump the version of a changed game, save it and wake up whoever is
+// waiting for it. The caller holds the lock.
+func (as *AsyncServer) commit(g *AsyncGame) error {
+	g.Version++
+	g.Updated = time.Now()
+	if err := as.save(g); err != nil {
+		return err
+	}
+	if c, ok := as.changed[g.ID]; ok {
+		close(c)
+		delete(as.changed, g.ID)
+	}
+	return nil
+}
+
+// Create a game. Without an opponent the game is open for anyone to
+// join, otherwise the token of the opponent is handed out with the one
+// of the player, for the player to pass on.
+func (as *AsyncServer) Create(player, opponent string) (*AsyncGame, error) {
+	if player == "" {
+		return nil, errNoPlayer
+	} else if opponent == player {
+		return nil, errAlreadyInGame
+	}
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	g := &AsyncGame{
+		ID:      uuid.New(),
+		X:       player,
+		O:       opponent,
+		Status:  AsyncOpen,
+		Moves:   []ttt.Position{},
+		Created: time.Now(),
+		XToken:  uuid.New(),
+	}
+	if opponent != "" {
+		g.Status = AsyncPlaying
+		g.OToken = uuid.New()
+	}
+	if err := as.commit(g); err != nil {
+		return nil, err
+	}
+	as.games[g.ID] = g
+	return g, nil
+}
+
+func (as *AsyncServer) Join(id, player string) (*AsyncGame, error) {
+	if player == "" {
+		return nil, errNoPlayer
+	}
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	g := as.games[id]
+	if g == nil {
+		return nil, errNoSuchGame
+	} else if g.Status != AsyncOpen {
+		return nil, errNotOpen
+	} else if g.X == player {
+		return nil, errAlreadyInGame
+	}
+	ng := *g
+	ng.O = player
+	ng.Status = AsyncPlaying
+	ng.OToken = uuid.New()
+	if err := as.commit(&ng); err != nil {
+		return nil, err
+	}
+	as.games[id] = &ng
+	return &ng, nil
+}
+
+func (as *AsyncServer) Move(id, player, token string, pos ttt.Position,
+	version int) (*AsyncGame, error) {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	g := as.games[id]
+	if g == nil {
+		return nil, errNoSuchGame
+	}
+	// play on a copy, so that a failed save leaves the game untouched
+	ng := *g
+	ng.Moves = append([]ttt.Position{}, g.Moves...)
+	if err := ng.play(player, token, pos, version); err != nil {
+		return nil, err
+	}
+	if err := as.commit(&ng); err != nil {
+		return nil, err
+	}
+	as.games[id] = &ng
+	return &ng, nil
+}
+
+// Get a game. With a version, wait until the game is past that
+// version or the timeout is over.
+func (as *AsyncServer) Get(id string, version int,
+	timeout time.Duration) (*AsyncGame, error) {
+	as.lock.Lock()
+	g := as.games[id]
+	if g == nil {
+		as.lock.Unlock()
+		return nil, errNoSuchGame
+	}
+	if g.Version > version || timeout <= 0 {
+		as.lock.Unlock()
+		return g, nil
+	}
+	c, ok := as.changed[id]
+	if !ok {
+		c = make(chan bool)
+		as.changed[id] = c
+	}
+	as.lock.Unlock()
+
+	select {
+	case <-c:
+	case <-time.After(timeout):
+	}
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	return as.games[id], nil
+}
+
+// Sorted with the most recently updated first
+type AsyncGames []*AsyncGame
+
+func (gs AsyncGames) Len() int           { return len(gs) }
+func (gs AsyncGames) Swap(i, j int)      { gs[i], gs[j] = gs[j], gs[i] }
+func (gs AsyncGames) Less(i, j int) bool { return gs[i].Updated.After(gs[j].Updated) }
+
+// Games of a player, most recently updated first
+func (as *AsyncServer) List(player string) AsyncGames {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	games := AsyncGames{}
+	for _, g := range as.games {
+		if g.markOf(player) != ttt.MarkEmpty {
+			games = append(games, g)
+		}
+	}
+	sort.Sort(games)
+	return games
+}
+
+type asyncRequest struct {
+	Player   string `json:"player"`
+	Token    string `json:"token,omitempty"`
+	Opponent string `json:"opponent,omitempty"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Version  int    `json:"version"`
+}
+
+// A game as answered to whoever created or joined it, with the token of
+// the seat taken and, for games against a named opponent, the one to pass
+// on to the opponent
+type asyncSeat struct {
+	*AsyncGame
+	Token         string `json:"token"`
+	OpponentToken string `json:"opponent_token,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	switch err {
+	case errNoSuchGame, errNoReview:
+		code = http.StatusNotFound
+	case errNotInGame:
+		code = http.StatusForbidden
+	case errStaleVersion, errNotOpen, errGameNotPlaying, errGameNotOver:
+		code = http.StatusConflict
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// Routes:
+//
+//	GET  /api/games?player=p                 games of a player
+//	POST /api/games                          create a game, get a token
+//	GET  /api/games/{id}[?version=v]         a game, waiting past v
+//	POST /api/games/{id}/join                join an open game, get a token
+//	POST /api/games/{id}/moves               play a move with the token
+func (as *AsyncServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/games"), "/")
+	parts := strings.Split(path, "/")
+	if path == "" {
+		parts = []string{}
+	}
+
+	var req asyncRequest
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		games := AsyncGames{}
+		for _, g := range as.List(r.URL.Query().Get("player")) {
+			games = append(games, g.public())
+		}
+		writeJSON(w, http.StatusOK, games)
+	case len(parts) == 0 && r.Method == "POST":
+		g, err := as.Create(req.Player, req.Opponent)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, &asyncSeat{
+			AsyncGame:     g.public(),
+			Token:         g.XToken,
+			OpponentToken: g.OToken,
+		})
+	case len(parts) == 1 && r.Method == "GET":
+		version := -1
+		timeout := time.Duration(0)
+		if v := r.URL.Query().Get("version"); v != "" {
+			var err error
+			if version, err = strconv.Atoi(v); err != nil {
+				writeError(w, err)
+				return
+			}
+			timeout = LongPollTimeout
+		}
+		g, err := as.Get(parts[0], version, timeout)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, g.public())
+	case len(parts) == 2 && parts[1] == "join" && r.Method == "POST":
+		g, err := as.Join(parts[0], req.Player)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &asyncSeat{
+			AsyncGame: g.public(),
+			Token:     g.OToken,
+		})
+	case len(parts) == 2 && parts[1] == "moves" && r.Method == "POST":
+		pos := ttt.Position{X: req.X, Y: req.Y}
+		g, err := as.Move(parts[0], req.Player, req.Token, pos,
+			req.Version)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, g.public())
+	default:
+		http.NotFound(w, r)
+	}
+}
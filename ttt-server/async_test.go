@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+func asyncSetup(t *testing.T) (*AsyncServer, string) {
+	dir, err := ioutil.TempDir("", "ttt-async")
+	assert.Nil(t, err)
+	as, err := NewAsyncServer(dir)
+	assert.Nil(t, err)
+	return as, dir
+}
+
+func asyncDo(as *AsyncServer, method, url string,
+	req interface{}) (*httptest.ResponseRecorder, *asyncSeat) {
+	var body bytes.Buffer
+	if req != nil {
+		json.NewEncoder(&body).Encode(req)
+	}
+	r, _ := http.NewRequest(method, url, &body)
+	w := httptest.NewRecorder()
+	as.ServeHTTP(w, r)
+	g := &asyncSeat{}
+	json.Unmarshal(w.Body.Bytes(), g)
+	return w, g
+}
+
+func TestAsyncGamePlay(t *testing.T) {
+	g := &AsyncGame{X: "adam", O: "john", Status: AsyncPlaying, Version: 1,
+		XToken: "x", OToken: "o"}
+	assert.Equal(t, g.play("eve", "x", ttt.Position{}, 1), errNotInGame)
+	assert.Equal(t, g.play("adam", "o", ttt.Position{}, 1), errNotInGame)
+	assert.Equal(t, g.play("adam", "x", ttt.Position{}, 0), errStaleVersion)
+	assert.Equal(t, g.play("john", "o", ttt.Position{}, 1).Error(),
+		ttt.ReasonNotYourTurn)
+	assert.Nil(t, g.play("adam", "x", ttt.Position{}, 1))
+	assert.Equal(t, g.Turn(), ttt.MarkO)
+	g.Version++
+	assert.Equal(t, g.play("john", "o", ttt.Position{}, 2).Error(),
+		ttt.ReasonCellTaken)
+	assert.Nil(t, g.play("john", "o", ttt.Position{X: 1}, 2))
+	g.Version++
+	assert.Nil(t, g.play("adam", "x", ttt.Position{Y: 1}, 3))
+	g.Version++
+	assert.Nil(t, g.play("john", "o", ttt.Position{X: 1, Y: 1}, 4))
+	g.Version++
+	assert.Nil(t, g.play("adam", "x", ttt.Position{Y: 2}, 5))
+	assert.Equal(t, g.Status, AsyncOver)
+	assert.Equal(t, g.Winner, ttt.MarkX)
+}
+
+func TestAsyncServerGame(t *testing.T) {
+	as, dir := asyncSetup(t)
+	defer os.RemoveAll(dir)
+
+	w, g := asyncDo(as, "POST", "/api/games", asyncRequest{Player: "adam"})
+	assert.Equal(t, w.Code, http.StatusCreated)
+	assert.Equal(t, g.Status, AsyncOpen)
+	assert.Equal(t, g.Version, 1)
+	assert.NotEqual(t, g.Token, "")
+	assert.Equal(t, g.OpponentToken, "")
+	assert.Equal(t, g.XToken, "")
+	adam := g.Token
+
+	w, _ = asyncDo(as, "POST", "/api/games",
+		asyncRequest{Player: "adam", Opponent: "adam"})
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	w, g = asyncDo(as, "POST", "/api/games/"+g.ID+"/join",
+		asyncRequest{Player: "john"})
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, g.Status, AsyncPlaying)
+	assert.Equal(t, g.O, "john")
+	assert.NotEqual(t, g.Token, "")
+	assert.NotEqual(t, g.Token, adam)
+	john := g.Token
+
+	w, _ = asyncDo(as, "POST", "/api/games/"+g.ID+"/moves",
+		asyncRequest{Player: "adam", Token: adam, X: 1, Y: 1, Version: 1})
+	assert.Equal(t, w.Code, http.StatusConflict)
+	// only the token of the seat plays for it
+	w, _ = asyncDo(as, "POST", "/api/games/"+g.ID+"/moves",
+		asyncRequest{Player: "adam", Token: john, X: 1, Y: 1, Version: 2})
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	w, g = asyncDo(as, "POST", "/api/games/"+g.ID+"/moves",
+		asyncRequest{Player: "adam", Token: adam, X: 1, Y: 1, Version: 2})
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, g.Grid.Get(ttt.Position{X: 1, Y: 1}), ttt.MarkX)
+	assert.Equal(t, g.Version, 3)
+	w, _ = asyncDo(as, "POST", "/api/games/"+g.ID+"/moves",
+		asyncRequest{Player: "eve", X: 0, Y: 1, Version: 3})
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	w, _ = asyncDo(as, "GET", "/api/games/no-such-game", nil)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	w, _ = asyncDo(as, "GET", "/api/games?player=john", nil)
+	var games []AsyncGame
+	json.Unmarshal(w.Body.Bytes(), &games)
+	assert.Equal(t, len(games), 1)
+	assert.Equal(t, games[0].ID, g.ID)
+
+	// games survive a restart
+	as, err := NewAsyncServer(dir)
+	assert.Nil(t, err)
+	w, restored := asyncDo(as, "GET", "/api/games/"+g.ID, nil)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, restored.Version, 3)
+	assert.Equal(t, restored.Moves, []ttt.Position{{X: 1, Y: 1}})
+	assert.Equal(t, restored.XToken, "")
+	assert.Equal(t, restored.OToken, "")
+	w, g = asyncDo(as, "POST", "/api/games/"+g.ID+"/moves",
+		asyncRequest{Player: "john", Token: john, X: 0, Y: 1, Version: 3})
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// the opponent named at creation gets a token through the creator
+	w, g = asyncDo(as, "POST", "/api/games",
+		asyncRequest{Player: "adam", Opponent: "john"})
+	assert.Equal(t, w.Code, http.StatusCreated)
+	assert.NotEqual(t, g.OpponentToken, "")
+	w, _ = asyncDo(as, "POST", "/api/games/"+g.ID+"/moves",
+		asyncRequest{Player: "adam", Token: g.Token, Version: 1})
+	assert.Equal(t, w.Code, http.StatusOK)
+	w, _ = asyncDo(as, "POST", "/api/games/"+g.ID+"/moves",
+		asyncRequest{Player: "john", Token: g.OpponentToken, X: 1,
+			Version: 2})
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestAsyncServerLongPoll(t *testing.T) {
+	as, dir := asyncSetup(t)
+	defer os.RemoveAll(dir)
+	g, err := as.Create("adam", "john")
+	assert.Nil(t, err)
+
+	done := make(chan *AsyncGame)
+	go func() {
+		_, polled := asyncDo(as, "GET", "/api/games/"+g.ID+"?version=1", nil)
+		done <- polled.AsyncGame
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, err = as.Move(g.ID, "adam", g.XToken, ttt.Position{}, 1)
+	assert.Nil(t, err)
+	polled := <-done
+	assert.Equal(t, polled.Version, 2)
+	assert.Equal(t, polled.Turn(), ttt.MarkO)
+
+	// nothing new
+	polled, err = as.Get(g.ID, 2, 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, polled.Version, 2)
+}
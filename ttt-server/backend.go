@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	// Node name of a server running on its own
+	LocalNode string = "local"
+
+	brokerQueue       string = "queue"
+	brokerPlayerKey   string = "player:"
+	brokerRoundKey    string = "round:"
+	brokerNodeTopic   string = "node."
+	brokerMatchPlayer int    = 2
+)
+
+var errNoSuchPlayer = errors.New("No such player")
+
+// A message between nodes: either a status for a player connected to
+// the receiving node, or an action for a round owned by it.
+type Envelope struct {
+	From   string            `json:"from"`
+	To     string            `json:"to,omitempty"`
+	Status *ttt.PlayerStatus `json:"status,omitempty"`
+	Action *ttt.PlayerAction `json:"action,omitempty"`
+}
+
+// Matchmaking queue, round ownership and message routing shared by the
+// nodes of a cluster
+type Backend interface {
+	// Name of this node
+	Node() string
+	// Put a player in the waiting queue
+	Enqueue(p *Player) error
+	// Take a player out of the waiting queue
+	Dequeue(p *Player) error
+	// Pop 2 waiting players, or nothing if there are not enough of them.
+	// Players of other nodes come back with their Node set.
+	Match() (*Player, *Player, error)
+	QueueLen() int
+	// Make this node the owner of a round
+	ClaimRound(id string) error
+	ReleaseRound(id string) error
+	// Node owning a round, empty if no node does
+	RoundOwner(id string) (string, error)
+	// Deliver an envelope to another node
+	Send(node string, e *Envelope) error
+}
+
+// A backend for a single server, keeping everything in memory
+type MemoryBackend struct {
+	Queue *PlayersQueue
+}
+
+func (mb *MemoryBackend) Node() string {
+	return LocalNode
+}
+
+func (mb *MemoryBackend) Enqueue(p *Player) error {
+	mb.Queue.Push(p)
+	return nil
+}
+
+func (mb *MemoryBackend) Dequeue(p *Player) error {
+	mb.Queue.Remove(p)
+	return nil
+}
+
+func (mb *MemoryBackend) Match() (*Player, *Player, error) {
+	if mb.Queue.Len() < brokerMatchPlayer {
+		return nil, nil, nil
+	}
+	return mb.Queue.Pop(), mb.Queue.Pop(), nil
+}
+
+func (mb *MemoryBackend) QueueLen() int {
+	return mb.Queue.Len()
+}
+
+func (mb *MemoryBackend) ClaimRound(id string) error {
+	return nil
+}
+
+func (mb *MemoryBackend) ReleaseRound(id string) error {
+	return nil
+}
+
+func (mb *MemoryBackend) RoundOwner(id string) (string, error) {
+	return LocalNode, nil
+}
+
+func (mb *MemoryBackend) Send(node string, e *Envelope) error {
+	return errors.New("No other node than " + LocalNode)
+}
+
+// A message broker such as Redis or NATS. Lists and keys are shared by
+// all nodes, and Pop must be atomic.
+type Broker interface {
+	Publish(topic string, data []byte) error
+	Subscribe(topic string, handler func(data []byte)) error
+	// Append an item to a list
+	Push(list, item string) error
+	// Put an item at the front of a list
+	PushFront(list, item string) error
+	// Remove and return the first n items of a list, or none if the list
+	// is shorter
+	Pop(list string, n int) ([]string, error)
+	// Remove an item from a list
+	Remove(list, item string) error
+	Len(list string) (int, error)
+	Set(key, value string) error
+	// Get a key, empty if it is not set
+	Get(key string) (string, error)
+	Delete(key string) error
+}
+
+// What other nodes need to know about a waiting player
+type PlayerRef struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+	Node  string `json:"node"`
+}
+
+// A backend sharing the queue and rounds of several nodes over a broker
+type BrokerBackend struct {
+	node   string
+	broker Broker
+	// Find a player connected to this node
+	lookup func(id string) *Player
+}
+
+func NewBrokerBackend(node string, broker Broker,
+	lookup func(id string) *Player) *BrokerBackend {
+	return &BrokerBackend{
+		node:   node,
+		broker: broker,
+		lookup: lookup,
+	}
+}
+
+// Handle the envelopes sent to this node
+func (bb *BrokerBackend) Listen(handler func(e *Envelope)) error {
+	return bb.broker.Subscribe(brokerNodeTopic+bb.node, func(data []byte) {
+		e := &Envelope{}
+		if err := json.Unmarshal(data, e); err != nil {
+			glog.Warningln("drop broken envelope", err)
+			return
+		}
+		handler(e)
+	})
+}
+
+func (bb *BrokerBackend) Node() string {
+	return bb.node
+}
+
+func (bb *BrokerBackend) Enqueue(p *Player) error {
+	data, err := json.Marshal(PlayerRef{
+		ID:    p.ID,
+		Name:  p.Name,
+		Score: p.Score,
+		Node:  bb.node,
+	})
+	if err != nil {
+		return err
+	}
+	if err := bb.broker.Set(brokerPlayerKey+p.ID, string(data)); err != nil {
+		return err
+	}
+	return bb.broker.Push(brokerQueue, p.ID)
+}
+
+func (bb *BrokerBackend) Dequeue(p *Player) error {
+	if err := bb.broker.Remove(brokerQueue, p.ID); err != nil {
+		return err
+	}
+	return bb.broker.Delete(brokerPlayerKey + p.ID)
+}
+
+// Turn a player ID into a local player, or a stand-in for a player of
+// another node
+func (bb *BrokerBackend) resolve(id string) (*Player, error) {
+	if p := bb.lookup(id); p != nil {
+		return p, nil
+	}
+	data, err := bb.broker.Get(brokerPlayerKey + id)
+	if err != nil {
+		return nil, err
+	} else if data == "" {
+		return nil, errNoSuchPlayer
+	}
+	ref := PlayerRef{}
+	if err := json.Unmarshal([]byte(data), &ref); err != nil {
+		return nil, err
+	}
+	if ref.Node == bb.node {
+		// the player left this node after joining the queue
+		return nil, errNoSuchPlayer
+	}
+	return &Player{
+		ID:    ref.ID,
+		Name:  ref.Name,
+		Score: ref.Score,
+		Node:  ref.Node,
+	}, nil
+}
+
+func (bb *BrokerBackend) Match() (*Player, *Player, error) {
+	ids, err := bb.broker.Pop(brokerQueue, brokerMatchPlayer)
+	if err != nil || len(ids) < brokerMatchPlayer {
+		return nil, nil, err
+	}
+	players := []*Player{}
+	for _, id := range ids {
+		p, err := bb.resolve(id)
+		if err != nil {
+			glog.Infoln("drop player", id, "from queue", err)
+			bb.broker.Delete(brokerPlayerKey + id)
+			continue
+		}
+		players = append(players, p)
+	}
+	if len(players) < brokerMatchPlayer {
+		// put back whoever is still around where they were, keeping the
+		// node they joined from
+		for i := len(players) - 1; i >= 0; i-- {
+			bb.broker.PushFront(brokerQueue, players[i].ID)
+		}
+		return nil, nil, nil
+	}
+	for _, p := range players {
+		bb.broker.Delete(brokerPlayerKey + p.ID)
+	}
+	return players[0], players[1], nil
+}
+
+func (bb *BrokerBackend) QueueLen() int {
+	n, err := bb.broker.Len(brokerQueue)
+	if err != nil {
+		glog.Warningln("can not get queue length", err)
+	}
+	return n
+}
+
+func (bb *BrokerBackend) ClaimRound(id string) error {
+	return bb.broker.Set(brokerRoundKey+id, bb.node)
+}
+
+func (bb *BrokerBackend) ReleaseRound(id string) error {
+	return bb.broker.Delete(brokerRoundKey + id)
+}
+
+func (bb *BrokerBackend) RoundOwner(id string) (string, error) {
+	return bb.broker.Get(brokerRoundKey + id)
+}
+
+func (bb *BrokerBackend) Send(node string, e *Envelope) error {
+	e.From = bb.node
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return bb.broker.Publish(brokerNodeTopic+node, data)
+}
+
+func (e *Envelope) repr() string {
+	repr := []string{"from = " + e.From}
+	if e.Status != nil {
+		repr = append(repr, "to = "+e.To, e.Status.Repr())
+	}
+	if e.Action != nil {
+		repr = append(repr, "action = "+e.Action.Cmd,
+			"seq = "+strconv.Itoa(e.Action.Seq))
+	}
+	return strings.Join(repr, ", ")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+func newNode(name string, broker Broker) *TTTServer {
+	group := make(Group)
+	players := make(map[string]*Player)
+	s := &TTTServer{
+		Players: &players,
+		Groups:  &group,
+		BenchPlayers: &PlayersQueue{
+			players: list.New(),
+		},
+		WithAIPlayers: make(chan *Player, BufferedChanLen),
+		Announce:      make(chan *Announcement, BufferedChanLen),
+	}
+	bb := NewBrokerBackend(name, broker, func(id string) *Player {
+		return (*s.Players)[id]
+	})
+	bb.Listen(s.HandleEnvelope)
+	s.Backend = bb
+	return s
+}
+
+// Process announcements of all nodes until none is left
+func flushNodes(nodes ...*TTTServer) {
+	for busy := true; busy; {
+		busy = false
+		for _, s := range nodes {
+			select {
+			case a := <-s.Announce:
+				s.ProcessAnnouncement(a)
+				busy = true
+			default:
+			}
+		}
+	}
+}
+
+func newLinePlayer(name string) (*Player, chan string) {
+	server, client := net.Pipe()
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(client)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return &Player{Line: &LineConn{conn: server}, ID: name, Name: name}, lines
+}
+
+// Wait for a line written to a player
+func waitLine(lines chan string, line string) bool {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case l := <-lines:
+			if l == line {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func TestBrokerBackendMatch(t *testing.T) {
+	broker := NewMemoryBroker()
+	local := &Player{ID: "p1", Name: "Adam"}
+	b1 := NewBrokerBackend("node-1", broker, func(id string) *Player {
+		if id == local.ID {
+			return local
+		}
+		return nil
+	})
+	b2 := NewBrokerBackend("node-2", broker, func(id string) *Player {
+		return nil
+	})
+
+	assert.Nil(t, b1.Enqueue(local))
+	p1, p2, err := b2.Match()
+	assert.Nil(t, p1)
+	assert.Nil(t, p2)
+	assert.Nil(t, err)
+	assert.Equal(t, b2.QueueLen(), 1)
+
+	remote := &Player{ID: "p2", Name: "Eve", Score: 5}
+	assert.Nil(t, b1.Enqueue(remote))
+	assert.Nil(t, b1.Dequeue(remote))
+	assert.Nil(t, b2.Enqueue(remote))
+	p1, p2, err = b1.Match()
+	assert.Nil(t, err)
+	assert.Equal(t, p1, local)
+	assert.Equal(t, *p2, Player{ID: "p2", Name: "Eve", Score: 5,
+		Node: "node-2"})
+	assert.Equal(t, b1.QueueLen(), 0)
+
+	// a player who is gone is dropped and the other one waits again
+	assert.Nil(t, b1.Enqueue(local))
+	assert.Nil(t, b1.Enqueue(&Player{ID: "p3"}))
+	broker.Delete(brokerPlayerKey + "p3")
+	p1, p2, err = b2.Match()
+	assert.Nil(t, p1)
+	assert.Nil(t, p2)
+	assert.Nil(t, err)
+	assert.Equal(t, b2.QueueLen(), 1)
+
+	// a player of another node waits again at the front, still on its
+	// node
+	assert.Nil(t, b1.Dequeue(local))
+	assert.Nil(t, b2.Enqueue(remote))
+	assert.Nil(t, b1.Enqueue(&Player{ID: "p3"}))
+	assert.Nil(t, b1.Enqueue(local))
+	broker.Delete(brokerPlayerKey + "p3")
+	p1, p2, err = b1.Match()
+	assert.Nil(t, p1)
+	assert.Nil(t, p2)
+	assert.Nil(t, err)
+	assert.Equal(t, broker.lists[brokerQueue], []string{"p2", "p1"})
+	p1, p2, err = b1.Match()
+	assert.Nil(t, err)
+	assert.Equal(t, *p1, Player{ID: "p2", Name: "Eve", Score: 5,
+		Node: "node-2"})
+	assert.Equal(t, p2, local)
+
+	assert.Nil(t, b1.ClaimRound("r1"))
+	owner, err := b2.RoundOwner("r1")
+	assert.Nil(t, err)
+	assert.Equal(t, owner, "node-1")
+	assert.Nil(t, b1.ReleaseRound("r1"))
+	owner, err = b2.RoundOwner("r1")
+	assert.Equal(t, owner, "")
+}
+
+func TestBrokerBackendRounds(t *testing.T) {
+	broker := NewMemoryBroker()
+	s1 := newNode("node-1", broker)
+	s2 := newNode("node-2", broker)
+	adam, adamLines := newLinePlayer("adam")
+	eve, eveLines := newLinePlayer("eve")
+
+	s1.ProcessAction(adam, &ttt.PlayerAction{Cmd: ttt.CmdJoin,
+		PlayerName: "Adam", Seq: 1})
+	s2.ProcessAction(eve, &ttt.PlayerAction{Cmd: ttt.CmdJoin,
+		PlayerName: "Eve", Seq: 1})
+	flushNodes(s1, s2)
+
+	// the node matching the players owns the round
+	assert.Equal(t, len(*s1.Groups), 0)
+	assert.Equal(t, len(*s2.Groups), 1)
+	assert.NotEqual(t, adam.RoundID, "")
+	assert.Equal(t, adam.RoundID, eve.RoundID)
+	owner, _ := broker.Get(brokerRoundKey + adam.RoundID)
+	assert.Equal(t, owner, "node-2")
+	assert.Equal(t, s1.Backend.QueueLen(), 0)
+
+	rd := (*s2.Groups)[eve.RoundID]
+	assert.Equal(t, rd.getPlayer(adam.ID).Node, "node-1")
+	assert.Equal(t, rd.getPlayer(eve.ID).Node, "")
+
+	// moves of the remote player are judged and acknowledged by the owner
+	move := &ttt.PlayerAction{
+		RoundID:  adam.RoundID,
+		PlayerID: adam.ID,
+		Pos:      ttt.Position{X: 1, Y: 1},
+		Cmd:      ttt.CmdMove,
+		Seq:      2,
+	}
+	if rd.CurrentPlayer.ID == eve.ID {
+		move.PlayerID = eve.ID
+		s2.ProcessAction(eve, move)
+		flushNodes(s1, s2)
+		assert.True(t, waitLine(eveLines, "ok"))
+	} else {
+		s1.ProcessAction(adam, move)
+		flushNodes(s1, s2)
+		assert.True(t, waitLine(adamLines, "ok"))
+	}
+	rd = (*s2.Groups)[eve.RoundID]
+	assert.Equal(t, rd.Seq, 1)
+	assert.Equal(t, rd.Grid.Get(ttt.Position{X: 1, Y: 1}), ttt.MarkX)
+
+	// leaving ends the round on the owner
+	s1.ProcessQuit(adam)
+	flushNodes(s1, s2)
+	assert.True(t, waitLine(eveLines, "status: "+ttt.StatusOtherLeft))
+	assert.Equal(t, len(*s2.Groups), 0)
+	owner, _ = broker.Get(brokerRoundKey + adam.RoundID)
+	assert.Equal(t, owner, "")
+	eve.Line.Close()
+}
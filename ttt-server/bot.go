@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -68,34 +69,90 @@ type AIPlayer struct {
 	Name       string
 	ID         string
 	Score      int
+	Mark       ttt.Mark
 	Conn       *websocket.Conn
 	VSID       string
 	VSName     string
 	VSScore    int
+	VSMark     ttt.Mark
 	RoundID    string
 	Status     string
 	Grid       ttt.Grid
+	Seq        int
+	Level      string
+	Engine     ttt.Engine
 	StatusChan chan *ttt.PlayerStatus
 	QuitChan   chan bool
 }
 
+// How long a bot may think about a move unless the manager says
+// otherwise
+const AIThinkTime = time.Second
+
+// How a level plays: the chance in percent of a random move, and how
+// many moves ahead minimax looks for the others. Experts play with the
+// engine of the manager.
+type aiLevel struct {
+	random int
+	depth  int
+}
+
+var aiLevels = map[string]aiLevel{
+	ttt.LevelBeginner:     {random: 50, depth: 2},
+	ttt.LevelIntermediate: {random: 0, depth: 2},
+	ttt.LevelExpert:       {random: 0, depth: 0},
+}
+
 type AIManager struct {
 	AIPlayers *map[string]*AIPlayer
+	// Guards AIPlayers, which connections, the dispatcher and the bots
+	// all use
+	lock sync.Mutex
+	// Name of the registered engine experts play with
+	Engine string
+	// How long bots may think about a move
+	ThinkTime time.Duration
+	done      chan bool // closed to stop all bots
+	running   sync.WaitGroup
+}
+
+// The engine for a bot of a level
+func (am *AIManager) engineFor(level string) ttt.Engine {
+	if l := aiLevels[level]; l.depth > 0 {
+		return &ttt.MinimaxEngine{Depth: l.depth}
+	}
+	e, err := ttt.NewEngine(am.Engine)
+	if err != nil {
+		glog.Warningln("can not use engine", am.Engine, err)
+		return &ttt.MinimaxEngine{}
+	}
+	return e
 }
 
-func (am *AIManager) NewAIPlayer(id string) *AIPlayer {
+func (am *AIManager) NewAIPlayer(id, level string) *AIPlayer {
+	if level == "" {
+		level = ttt.LevelExpert
+	}
 	p := &AIPlayer{
+		Level:      level,
+		Engine:     am.engineFor(level),
 		StatusChan: make(chan *ttt.PlayerStatus, BufferedChanLen),
 		QuitChan:   make(chan bool, BufferedChanLen),
 	}
+	am.running.Add(1)
 	go p.Play()
+	am.lock.Lock()
 	(*am.AIPlayers)[id] = p
-	glog.Infoln("total AI players", len((*am.AIPlayers)))
+	n := len(*am.AIPlayers)
+	am.lock.Unlock()
+	glog.Infoln("total AI players", n)
 	return p
 }
 
 func (am *AIManager) UpdatePlayer(s *ttt.PlayerStatus) error {
+	am.lock.Lock()
 	p := (*am.AIPlayers)[s.PlayerID]
+	am.lock.Unlock()
 	if p == nil {
 		glog.Warningln("Can not find such player")
 		return errors.New("Can not find such player")
@@ -125,22 +182,34 @@ func (ai *AIPlayer) Update(s *ttt.PlayerStatus) error {
 	}
 	ai.ID = s.PlayerID
 	ai.Score = s.PlayerScore
+	ai.Mark = s.Mark
 	ai.VSID = s.VSID
 	ai.VSName = s.VSName
 	ai.VSScore = s.VSScore
+	ai.VSMark = s.VSMark
 	ai.Status = s.Status
-	if s.GridSnap != nil {
-		ai.Grid = *s.GridSnap
-	} else {
-		var grid ttt.Grid
-		ai.Grid = grid
-	}
+	seq, ok := ai.Grid.Sync(ai.Seq, s)
+	ai.Seq = seq
 	if ttt.IsAIOverStatus(ai.Status) {
 		ai.QuitChan <- true
 	}
+	if !ok {
+		ai.Resync()
+		return errors.New("Missed some moves, waiting for a snapshot")
+	}
 	return nil
 }
 
+// Ask the server for a full snapshot of the round
+func (ai *AIPlayer) Resync() {
+	playerActions <- ttt.PlayerAction{
+		RoundID:    ai.RoundID,
+		PlayerID:   ai.ID,
+		PlayerName: ai.Name,
+		Cmd:        ttt.CmdResync,
+	}
+}
+
 func (ai *AIPlayer) Move() {
 	if ai.Status != ttt.StatusYourTurn {
 		return
@@ -160,32 +229,53 @@ func (ai *AIPlayer) Move() {
 
 func (ai *AIPlayer) GetBestPosition() ttt.Position {
 	g := ttt.Game{
-		CurrentPlayer: ai.ID,
-		NextPlayer:    ai.VSID,
+		CurrentPlayer: ai.Mark,
+		NextPlayer:    ai.VSMark,
 		Grd:           ai.Grid,
 	}
-	r := g.GetBestMove(ai.ID)
+	engine := ai.Engine
+	if engine == nil {
+		engine = &ttt.MinimaxEngine{}
+	}
+	if l := aiLevels[ai.Level]; l.random > 0 && ttt.RandInt(100) < l.random {
+		engine = &ttt.RandomEngine{}
+	}
+	r, _ := engine.Move(g, am.ThinkTime)
 	return r.Pos
 }
 
 func (ai *AIPlayer) Play() {
+	defer am.running.Done()
 	for {
 		select {
 		case s := <-ai.StatusChan:
-			ai.Update(s)
-			ai.Move()
+			if ai.Update(s) == nil {
+				ai.Move()
+			}
 		case <-ai.QuitChan:
+			am.lock.Lock()
 			delete((*am.AIPlayers), ai.ID)
-			break
+			am.lock.Unlock()
+			return
+		case <-am.done:
+			return
 		}
 	}
 }
 
+// Stop all bots and wait for them to return
+func (am *AIManager) Shutdown() {
+	close(am.done)
+	am.running.Wait()
+}
+
 func InitAIManager() *AIManager {
 	players := make(map[string]*AIPlayer)
 	am := &AIManager{
 		AIPlayers: &players,
+		Engine:    ttt.EngineDeepening,
+		ThinkTime: AIThinkTime,
+		done:      make(chan bool),
 	}
-	go am.Dispatch()
 	return am
 }
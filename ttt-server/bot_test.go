@@ -4,13 +4,14 @@ import (
 	"container/list"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/wujiang/tic-tac-toe"
 )
 
 func amSetup() {
-	am.NewAIPlayer("bot1")
+	am.NewAIPlayer("bot1", "")
 }
 
 func amTeardown() {
@@ -21,12 +22,17 @@ func amTeardown() {
 		players: list.New(),
 		lock:    sync.Mutex{},
 	}
+	ttts.Backend = &MemoryBackend{Queue: ttts.BenchPlayers}
 	ttts.WithAIPlayers = make(chan *Player, BufferedChanLen)
 	ttts.Announce = make(chan *Announcement, BufferedChanLen)
 	ttts.Groups = &group
+	ttts.closing = make(chan bool)
+	ttts.detached = nil
 
+	am.Shutdown()
 	aiPlayers := make(map[string]*AIPlayer)
 	am.AIPlayers = &aiPlayers
+	am.done = make(chan bool)
 }
 
 func TestAIPlayerUpdate(t *testing.T) {
@@ -36,9 +42,11 @@ func TestAIPlayerUpdate(t *testing.T) {
 		PlayerName:  "AI",
 		PlayerID:    "bot1",
 		PlayerScore: 1,
+		Mark:        ttt.MarkO,
 		VSID:        "player-1",
 		VSName:      "Adam",
 		VSScore:     -1,
+		VSMark:      ttt.MarkX,
 		Status:      ttt.StatusWait,
 	}
 	ap := (*am.AIPlayers)["bot1"]
@@ -48,5 +56,81 @@ func TestAIPlayerUpdate(t *testing.T) {
 	assert.Equal(t, ap.VSName, "Adam")
 	assert.Equal(t, ap.VSScore, -1)
 	assert.Equal(t, ap.Status, ttt.StatusWait)
+	assert.Equal(t, ap.Mark, ttt.MarkO)
+	assert.Equal(t, ap.VSMark, ttt.MarkX)
 	amTeardown()
 }
+
+func TestAIPlayerUpdateMissedMove(t *testing.T) {
+	amSetup()
+	ap := (*am.AIPlayers)["bot1"]
+	ps := &ttt.PlayerStatus{
+		RoundID:  "round-1",
+		PlayerID: "bot1",
+		VSID:     "player-1",
+		Status:   ttt.StatusYourTurn,
+		Move:     &ttt.MoveEvent{Seq: 2, Pos: ttt.Position{X: 1, Y: 1}},
+	}
+	assert.NotNil(t, ap.Update(ps))
+	assert.Equal(t, ap.Seq, 0)
+	assert.Equal(t, len(playerActions), 1)
+	a := <-playerActions
+	assert.Equal(t, a.Cmd, ttt.CmdResync)
+
+	ps.Move.Seq = 1
+	ps.Move.Mark = ttt.MarkX
+	assert.Nil(t, ap.Update(ps))
+	assert.Equal(t, ap.Seq, 1)
+	assert.Equal(t, ap.Grid.Get(ttt.Position{X: 1, Y: 1}), ttt.MarkX)
+	amTeardown()
+}
+
+func TestAIPlayerGetBestPosition(t *testing.T) {
+	g, err := ttt.ParseGame("X1O/1X1/3 O")
+	assert.Nil(t, err)
+	ap := &AIPlayer{Mark: ttt.MarkO, VSMark: ttt.MarkX, Grid: g.Grd}
+	assert.Equal(t, ap.GetBestPosition(), ttt.Position{X: 2, Y: 2})
+	for _, level := range []string{ttt.LevelIntermediate, ttt.LevelExpert} {
+		ap.Level = level
+		assert.Equal(t, ap.GetBestPosition(), ttt.Position{X: 2, Y: 2})
+	}
+	// beginners may play anywhere
+	ap.Level = ttt.LevelBeginner
+	pos := ap.GetBestPosition()
+	assert.Equal(t, ap.Grid.Get(pos), ttt.MarkEmpty)
+}
+
+func TestAIManagerengineFor(t *testing.T) {
+	assert.Equal(t, am.engineFor(ttt.LevelIntermediate),
+		&ttt.MinimaxEngine{Depth: 2})
+	assert.Equal(t, am.engineFor(ttt.LevelExpert), &ttt.DeepeningEngine{})
+	am.Engine = ttt.EngineRules
+	assert.Equal(t, am.engineFor(ttt.LevelExpert), &ttt.RuleEngine{})
+	am.Engine = "no-such-engine"
+	assert.Equal(t, am.engineFor(ttt.LevelExpert), &ttt.MinimaxEngine{})
+	am.Engine = ttt.EngineDeepening
+}
+
+func TestAIPlayerThinkTime(t *testing.T) {
+	// a bot short of time still plays a free cell
+	am.ThinkTime = time.Nanosecond
+	defer func() { am.ThinkTime = AIThinkTime }()
+	ap := &AIPlayer{Mark: ttt.MarkX, VSMark: ttt.MarkO,
+		Level: ttt.LevelExpert, Engine: &ttt.DeepeningEngine{}}
+	start := time.Now()
+	assert.Equal(t, ap.GetBestPosition(), ttt.Position{X: 1, Y: 1})
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestAIManagerShutdown(t *testing.T) {
+	// a bot stops once its round is over
+	p := am.NewAIPlayer("bot1", "")
+	p.ID = "bot1"
+	p.QuitChan <- true
+	am.running.Wait()
+	assert.Nil(t, (*am.AIPlayers)["bot1"])
+
+	am.NewAIPlayer("bot2", ttt.LevelBeginner)
+	am.Shutdown()
+	am.done = make(chan bool)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+const (
+	brokerOpPublish   string = "publish"
+	brokerOpSubscribe string = "subscribe"
+	brokerOpPush      string = "push"
+	brokerOpPushFront string = "pushfront"
+	brokerOpPop       string = "pop"
+	brokerOpRemove    string = "remove"
+	brokerOpLen       string = "len"
+	brokerOpSet       string = "set"
+	brokerOpGet       string = "get"
+	brokerOpDelete    string = "delete"
+	// A message published to a topic the connection subscribed to
+	brokerOpMessage string = "message"
+)
+
+var (
+	errBrokerOp     = errors.New("Unknown broker operation")
+	errBrokerClosed = errors.New("Broker connection is closed")
+)
+
+// A broker kept in the memory of one node, delivering messages
+// synchronously. Other nodes reach it through ServeBroker.
+type MemoryBroker struct {
+	handlers map[string][]func(data []byte)
+	lists    map[string][]string
+	keys     map[string]string
+	lock     sync.Mutex
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		handlers: make(map[string][]func(data []byte)),
+		lists:    make(map[string][]string),
+		keys:     make(map[string]string),
+	}
+}
+
+func (mb *MemoryBroker) Publish(topic string, data []byte) error {
+	mb.lock.Lock()
+	handlers := mb.handlers[topic]
+	mb.lock.Unlock()
+	for _, h := range handlers {
+		h(data)
+	}
+	return nil
+}
+
+func (mb *MemoryBroker) Subscribe(topic string, handler func(data []byte)) error {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.handlers[topic] = append(mb.handlers[topic], handler)
+	return nil
+}
+
+func (mb *MemoryBroker) Push(list, item string) error {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.lists[list] = append(mb.lists[list], item)
+	return nil
+}
+
+func (mb *MemoryBroker) PushFront(list, item string) error {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.lists[list] = append([]string{item}, mb.lists[list]...)
+	return nil
+}
+
+func (mb *MemoryBroker) Pop(list string, n int) ([]string, error) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	if len(mb.lists[list]) < n {
+		return nil, nil
+	}
+	items := mb.lists[list][:n]
+	mb.lists[list] = mb.lists[list][n:]
+	return items, nil
+}
+
+func (mb *MemoryBroker) Remove(list, item string) error {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	items := []string{}
+	for _, i := range mb.lists[list] {
+		if i != item {
+			items = append(items, i)
+		}
+	}
+	mb.lists[list] = items
+	return nil
+}
+
+func (mb *MemoryBroker) Len(list string) (int, error) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	return len(mb.lists[list]), nil
+}
+
+func (mb *MemoryBroker) Set(key, value string) error {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.keys[key] = value
+	return nil
+}
+
+func (mb *MemoryBroker) Get(key string) (string, error) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	return mb.keys[key], nil
+}
+
+func (mb *MemoryBroker) Delete(key string) error {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	delete(mb.keys, key)
+	return nil
+}
+
+// A request, reply or published message on a broker connection, one
+// JSON object per line. Name is the topic, list or key of the operation.
+type brokerMessage struct {
+	ID    int      `json:"id,omitempty"`
+	Op    string   `json:"op,omitempty"`
+	Name  string   `json:"name,omitempty"`
+	Value string   `json:"value,omitempty"`
+	Data  []byte   `json:"data,omitempty"`
+	N     int      `json:"n,omitempty"`
+	Items []string `json:"items,omitempty"`
+	Err   string   `json:"error,omitempty"`
+}
+
+// A connection writing broker messages
+type brokerConn struct {
+	conn net.Conn
+	enc  *json.Encoder
+	lock sync.Mutex
+}
+
+func newBrokerConn(conn net.Conn) *brokerConn {
+	return &brokerConn{conn: conn, enc: json.NewEncoder(conn)}
+}
+
+func (bc *brokerConn) write(m *brokerMessage) error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	return bc.enc.Encode(m)
+}
+
+// Share a broker with the nodes connecting to a listener until it is
+// closed
+func ServeBroker(l net.Listener, broker Broker) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveBrokerConn(conn, broker)
+	}
+}
+
+func serveBrokerConn(conn net.Conn, broker Broker) {
+	defer conn.Close()
+	bc := newBrokerConn(conn)
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, ReadBufferSize*64)
+	for scanner.Scan() {
+		m := &brokerMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), m); err != nil {
+			glog.Warningln("drop broken broker request", err)
+			continue
+		}
+		reply := &brokerMessage{ID: m.ID}
+		var err error
+		switch m.Op {
+		case brokerOpPublish:
+			err = broker.Publish(m.Name, m.Data)
+		case brokerOpSubscribe:
+			topic := m.Name
+			err = broker.Subscribe(topic, func(data []byte) {
+				bc.write(&brokerMessage{
+					Op:   brokerOpMessage,
+					Name: topic,
+					Data: data,
+				})
+			})
+		case brokerOpPush:
+			err = broker.Push(m.Name, m.Value)
+		case brokerOpPushFront:
+			err = broker.PushFront(m.Name, m.Value)
+		case brokerOpPop:
+			reply.Items, err = broker.Pop(m.Name, m.N)
+		case brokerOpRemove:
+			err = broker.Remove(m.Name, m.Value)
+		case brokerOpLen:
+			reply.N, err = broker.Len(m.Name)
+		case brokerOpSet:
+			err = broker.Set(m.Name, m.Value)
+		case brokerOpGet:
+			reply.Value, err = broker.Get(m.Name)
+		case brokerOpDelete:
+			err = broker.Delete(m.Name)
+		default:
+			err = errBrokerOp
+		}
+		if err != nil {
+			reply.Err = err.Error()
+		}
+		if err := bc.write(reply); err != nil {
+			break
+		}
+	}
+	glog.Infoln("lost broker connection to", conn.RemoteAddr())
+}
+
+// A broker served by another node
+type RemoteBroker struct {
+	bc       *brokerConn
+	lastID   int
+	pending  map[int]chan *brokerMessage
+	handlers map[string][]func(data []byte)
+	closed   bool
+	lock     sync.Mutex
+	// Published messages, handled in order apart from the replies so
+	// handlers can use the broker themselves
+	messages chan *brokerMessage
+}
+
+func DialBroker(addr string) (*RemoteBroker, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	rb := &RemoteBroker{
+		bc:       newBrokerConn(conn),
+		pending:  make(map[int]chan *brokerMessage),
+		handlers: make(map[string][]func(data []byte)),
+		messages: make(chan *brokerMessage, BufferedChanLen),
+	}
+	go rb.read()
+	go rb.dispatch()
+	return rb, nil
+}
+
+func (rb *RemoteBroker) Close() error {
+	return rb.bc.conn.Close()
+}
+
+func (rb *RemoteBroker) read() {
+	scanner := bufio.NewScanner(rb.bc.conn)
+	scanner.Buffer(nil, ReadBufferSize*64)
+	for scanner.Scan() {
+		m := &brokerMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), m); err != nil {
+			glog.Warningln("drop broken broker reply", err)
+			continue
+		}
+		if m.Op == brokerOpMessage {
+			rb.messages <- m
+			continue
+		}
+		rb.lock.Lock()
+		reply := rb.pending[m.ID]
+		delete(rb.pending, m.ID)
+		rb.lock.Unlock()
+		if reply != nil {
+			reply <- m
+		}
+	}
+	glog.Warningln("lost connection to broker")
+	rb.lock.Lock()
+	rb.closed = true
+	for id, reply := range rb.pending {
+		close(reply)
+		delete(rb.pending, id)
+	}
+	rb.lock.Unlock()
+	close(rb.messages)
+}
+
+func (rb *RemoteBroker) dispatch() {
+	for m := range rb.messages {
+		rb.lock.Lock()
+		handlers := rb.handlers[m.Name]
+		rb.lock.Unlock()
+		for _, h := range handlers {
+			h(m.Data)
+		}
+	}
+}
+
+// Send a request and wait for its reply
+func (rb *RemoteBroker) do(m *brokerMessage) (*brokerMessage, error) {
+	reply := make(chan *brokerMessage, 1)
+	rb.lock.Lock()
+	if rb.closed {
+		rb.lock.Unlock()
+		return nil, errBrokerClosed
+	}
+	rb.lastID++
+	m.ID = rb.lastID
+	rb.pending[m.ID] = reply
+	rb.lock.Unlock()
+	if err := rb.bc.write(m); err != nil {
+		rb.lock.Lock()
+		delete(rb.pending, m.ID)
+		rb.lock.Unlock()
+		return nil, err
+	}
+	r, ok := <-reply
+	if !ok {
+		return nil, errBrokerClosed
+	} else if r.Err != "" {
+		return r, errors.New(r.Err)
+	}
+	return r, nil
+}
+
+func (rb *RemoteBroker) Publish(topic string, data []byte) error {
+	_, err := rb.do(&brokerMessage{Op: brokerOpPublish, Name: topic,
+		Data: data})
+	return err
+}
+
+func (rb *RemoteBroker) Subscribe(topic string, handler func(data []byte)) error {
+	rb.lock.Lock()
+	rb.handlers[topic] = append(rb.handlers[topic], handler)
+	first := len(rb.handlers[topic]) == 1
+	rb.lock.Unlock()
+	if !first {
+		return nil
+	}
+	_, err := rb.do(&brokerMessage{Op: brokerOpSubscribe, Name: topic})
+	return err
+}
+
+func (rb *RemoteBroker) Push(list, item string) error {
+	_, err := rb.do(&brokerMessage{Op: brokerOpPush, Name: list,
+		Value: item})
+	return err
+}
+
+func (rb *RemoteBroker) PushFront(list, item string) error {
+	_, err := rb.do(&brokerMessage{Op: brokerOpPushFront, Name: list,
+		Value: item})
+	return err
+}
+
+func (rb *RemoteBroker) Pop(list string, n int) ([]string, error) {
+	r, err := rb.do(&brokerMessage{Op: brokerOpPop, Name: list, N: n})
+	if err != nil {
+		return nil, err
+	}
+	return r.Items, nil
+}
+
+func (rb *RemoteBroker) Remove(list, item string) error {
+	_, err := rb.do(&brokerMessage{Op: brokerOpRemove, Name: list,
+		Value: item})
+	return err
+}
+
+func (rb *RemoteBroker) Len(list string) (int, error) {
+	r, err := rb.do(&brokerMessage{Op: brokerOpLen, Name: list})
+	if err != nil {
+		return 0, err
+	}
+	return r.N, nil
+}
+
+func (rb *RemoteBroker) Set(key, value string) error {
+	_, err := rb.do(&brokerMessage{Op: brokerOpSet, Name: key,
+		Value: value})
+	return err
+}
+
+func (rb *RemoteBroker) Get(key string) (string, error) {
+	r, err := rb.do(&brokerMessage{Op: brokerOpGet, Name: key})
+	if err != nil {
+		return "", err
+	}
+	return r.Value, nil
+}
+
+func (rb *RemoteBroker) Delete(key string) error {
+	_, err := rb.do(&brokerMessage{Op: brokerOpDelete, Name: key})
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteBroker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go ServeBroker(l, NewMemoryBroker())
+
+	rb1, err := DialBroker(l.Addr().String())
+	assert.Nil(t, err)
+	defer rb1.Close()
+	rb2, err := DialBroker(l.Addr().String())
+	assert.Nil(t, err)
+	defer rb2.Close()
+
+	assert.Nil(t, rb1.Push("queue", "a"))
+	assert.Nil(t, rb2.Push("queue", "b"))
+	assert.Nil(t, rb2.PushFront("queue", "c"))
+	n, err := rb1.Len("queue")
+	assert.Nil(t, err)
+	assert.Equal(t, n, 3)
+	assert.Nil(t, rb1.Remove("queue", "a"))
+	items, err := rb2.Pop("queue", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, len(items), 0)
+	items, err = rb2.Pop("queue", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, items, []string{"c", "b"})
+
+	assert.Nil(t, rb1.Set("round:1", "node-1"))
+	v, err := rb2.Get("round:1")
+	assert.Nil(t, err)
+	assert.Equal(t, v, "node-1")
+	assert.Nil(t, rb2.Delete("round:1"))
+	v, err = rb1.Get("round:1")
+	assert.Nil(t, err)
+	assert.Equal(t, v, "")
+
+	got := make(chan string, 1)
+	assert.Nil(t, rb2.Subscribe("node.node-2", func(data []byte) {
+		// handlers may use the broker while they run
+		rb2.Set("seen", string(data))
+		got <- string(data)
+	}))
+	assert.Nil(t, rb1.Publish("node.node-2", []byte("hello")))
+	select {
+	case data := <-got:
+		assert.Equal(t, data, "hello")
+	case <-time.After(time.Second):
+		t.Error("message was not delivered")
+	}
+	v, err = rb1.Get("seen")
+	assert.Nil(t, err)
+	assert.Equal(t, v, "hello")
+
+	rb1.Close()
+	assert.NotNil(t, rb1.Push("queue", "a"))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go-uuid/uuid"
+
+	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	DefaultLineName string = "Guest"
+
+	LineHelp = `Commands:
+  JOIN [name]            play against another player
+  JOINAI [level] [name]  play against a beginner, intermediate or expert bot
+  MOVE x y               mark column x, row y (1-3)
+  SAY text               chat with the other player
+  HINT                   ask for a good move, if the round allows it
+  RESUME token           pick up a session after the server restarted
+  QUIT                   leave the game`
+)
+
+// Returned by parseLine when the player asks for help
+var errLineHelp = errors.New("help")
+
+// A player connected over plain TCP, typing commands line by line
+type LineConn struct {
+	conn net.Conn
+	seq  int
+	lock sync.Mutex
+	// Replica of the grid of the player's round
+	grid     ttt.Grid
+	roundSeq int
+}
+
+func (lc *LineConn) writeLine(s string) error {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	_, err := lc.conn.Write([]byte(s + "\n"))
+	return err
+}
+
+// Write a status in a human readable form, along with the grid of the
+// player's round if any
+func (lc *LineConn) WriteStatus(ps *ttt.PlayerStatus) error {
+	if ps.Ack != nil {
+		if ps.Ack.OK {
+			return lc.writeLine("ok")
+		}
+		return lc.writeLine("error: " + ps.Ack.Reason)
+	}
+	if ps.Status == "" {
+		return lc.writeLine("notice: " + ps.Notice)
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("status: ")
+	buffer.WriteString(ps.Status)
+	if ps.VSName != "" {
+		buffer.WriteString(" (you are ")
+		buffer.WriteString(ps.Mark.String())
+		buffer.WriteString(" with ")
+		buffer.WriteString(strconv.Itoa(ps.PlayerScore))
+		buffer.WriteString(", ")
+		buffer.WriteString(ps.VSName)
+		buffer.WriteString(" is ")
+		buffer.WriteString(ps.VSMark.String())
+		buffer.WriteString(" with ")
+		buffer.WriteString(strconv.Itoa(ps.VSScore))
+		buffer.WriteString(")")
+	}
+	seq, ok := lc.grid.Sync(lc.roundSeq, ps)
+	if !ok {
+		glog.Infoln("line player missed moves before", ps.Seq)
+	}
+	lc.roundSeq = seq
+	if ps.Token != "" {
+		buffer.WriteString("\ntoken: ")
+		buffer.WriteString(ps.Token)
+	}
+	if ps.RoundID != "" {
+		buffer.WriteString("\n")
+		buffer.WriteString(renderGrid(&lc.grid))
+	}
+	return lc.writeLine(buffer.String())
+}
+
+func (lc *LineConn) Close() error {
+	return lc.conn.Close()
+}
+
+// Draw a grid in ASCII, with column and row numbers
+func renderGrid(g *ttt.Grid) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("   ")
+	for x := 0; x < ttt.Size; x++ {
+		buffer.WriteString(" " + strconv.Itoa(x+1) + "  ")
+	}
+	buffer.WriteString("\n")
+	for y := 0; y < ttt.Size; y++ {
+		if y > 0 {
+			buffer.WriteString("   " +
+				strings.Repeat("---+", ttt.Size-1) + "---\n")
+		}
+		buffer.WriteString(strconv.Itoa(y+1) + "  ")
+		for x := 0; x < ttt.Size; x++ {
+			if x > 0 {
+				buffer.WriteString("|")
+			}
+			m := g.Get(ttt.Position{X: x, Y: y})
+			if m == ttt.MarkEmpty {
+				buffer.WriteString("   ")
+			} else {
+				buffer.WriteString(" " + m.String() + " ")
+			}
+		}
+		buffer.WriteString("\n")
+	}
+	lines := strings.Split(strings.TrimRight(buffer.String(), "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Parse a line typed by a player. Empty lines give no action.
+func parseLine(line string) (*ttt.PlayerAction, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	m := &ttt.PlayerAction{}
+	switch strings.ToUpper(fields[0]) {
+	case "JOIN":
+		m.Cmd = ttt.CmdJoin
+	case "JOINAI":
+		m.Cmd = ttt.CmdJoinAI
+	case "MOVE":
+		if len(fields) != 3 {
+			return nil, errors.New("usage: MOVE x y")
+		}
+		x, errX := strconv.Atoi(fields[1])
+		y, errY := strconv.Atoi(fields[2])
+		if errX != nil || errY != nil {
+			return nil, errors.New("usage: MOVE x y")
+		}
+		m.Cmd = ttt.CmdMove
+		m.Pos = ttt.Position{X: x - 1, Y: y - 1}
+	case "RESUME":
+		if len(fields) != 2 {
+			return nil, errors.New("usage: RESUME token")
+		}
+		m.Cmd = ttt.CmdResume
+		m.Token = fields[1]
+	case "SAY":
+		if len(fields) < 2 {
+			return nil, errors.New("usage: SAY text")
+		}
+		m.Cmd = ttt.CmdChat
+		m.Text = strings.Join(fields[1:], " ")
+	case "HINT":
+		m.Cmd = ttt.CmdHint
+	case "QUIT":
+		m.Cmd = ttt.CmdQuit
+	case "HELP":
+		return nil, errLineHelp
+	default:
+		return nil, errors.New("unknown command " + fields[0] +
+			", type HELP for help")
+	}
+	if m.Cmd == ttt.CmdJoinAI && len(fields) > 1 &&
+		ttt.IsValidLevel(strings.ToLower(fields[1])) {
+		m.Level = strings.ToLower(fields[1])
+		fields = fields[1:]
+	}
+	if m.Cmd == ttt.CmdJoin || m.Cmd == ttt.CmdJoinAI {
+		m.PlayerName = strings.Join(fields[1:], " ")
+		if m.PlayerName == "" {
+			m.PlayerName = DefaultLineName
+		}
+	}
+	return m, nil
+}
+
+// Accept plain TCP players until the listener is closed
+func ServeLines(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go LineHandler(conn)
+	}
+}
+
+func LineHandler(conn net.Conn) {
+	lc := &LineConn{conn: conn}
+	p := &Player{Line: lc, ID: uuid.New()}
+	lc.writeLine(ttt.Title + "\n" + LineHelp)
+	ttts.Connect(p)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		m, err := parseLine(scanner.Text())
+		if err == errLineHelp {
+			lc.writeLine(LineHelp)
+			continue
+		} else if err != nil {
+			lc.writeLine("error: " + err.Error())
+			continue
+		}
+		if m == nil {
+			continue
+		}
+		m.RoundID = p.RoundID
+		m.PlayerID = p.ID
+		lc.seq++
+		m.Seq = lc.seq
+		if !ttts.ProcessAction(p, m) {
+			return
+		}
+	}
+	glog.Infoln("lost line connection to player", p.repr())
+	ttts.ProcessQuit(p)
+}
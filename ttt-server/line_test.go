@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+func TestParseLine(t *testing.T) {
+	m, err := parseLine("")
+	assert.Nil(t, m)
+	assert.Nil(t, err)
+
+	m, err = parseLine("join Adam Smith")
+	assert.Nil(t, err)
+	assert.Equal(t, *m, ttt.PlayerAction{
+		PlayerName: "Adam Smith",
+		Cmd:        ttt.CmdJoin,
+	})
+
+	m, err = parseLine("JOINAI")
+	assert.Nil(t, err)
+	assert.Equal(t, *m, ttt.PlayerAction{
+		PlayerName: DefaultLineName,
+		Cmd:        ttt.CmdJoinAI,
+	})
+
+	m, err = parseLine("joinai Beginner Adam")
+	assert.Nil(t, err)
+	assert.Equal(t, *m, ttt.PlayerAction{
+		PlayerName: "Adam",
+		Cmd:        ttt.CmdJoinAI,
+		Level:      ttt.LevelBeginner,
+	})
+
+	m, err = parseLine("MOVE 1 3")
+	assert.Nil(t, err)
+	assert.Equal(t, *m, ttt.PlayerAction{
+		Pos: ttt.Position{X: 0, Y: 2},
+		Cmd: ttt.CmdMove,
+	})
+
+	m, err = parseLine("resume abc")
+	assert.Nil(t, err)
+	assert.Equal(t, *m, ttt.PlayerAction{
+		Cmd:   ttt.CmdResume,
+		Token: "abc",
+	})
+
+	m, err = parseLine("say good  game")
+	assert.Nil(t, err)
+	assert.Equal(t, *m, ttt.PlayerAction{
+		Cmd:  ttt.CmdChat,
+		Text: "good game",
+	})
+
+	m, err = parseLine("hint")
+	assert.Nil(t, err)
+	assert.Equal(t, m.Cmd, ttt.CmdHint)
+
+	m, err = parseLine("quit")
+	assert.Nil(t, err)
+	assert.Equal(t, m.Cmd, ttt.CmdQuit)
+
+	_, err = parseLine("MOVE 1")
+	assert.NotNil(t, err)
+	_, err = parseLine("MOVE a b")
+	assert.NotNil(t, err)
+	_, err = parseLine("RESUME")
+	assert.NotNil(t, err)
+	_, err = parseLine("dance")
+	assert.NotNil(t, err)
+	_, err = parseLine("help")
+	assert.Equal(t, err, errLineHelp)
+}
+
+func TestRenderGrid(t *testing.T) {
+	grid := ttt.Grid{
+		{ttt.MarkX, ttt.MarkEmpty, ttt.MarkO},
+		{ttt.MarkEmpty, ttt.MarkX},
+	}
+	expected := "" +
+		"    1   2   3\n" +
+		"1   X |   |\n" +
+		"   ---+---+---\n" +
+		"2     | X |\n" +
+		"   ---+---+---\n" +
+		"3   O |   |"
+	assert.Equal(t, renderGrid(&grid), expected)
+}
+
+func TestLineHandler(t *testing.T) {
+	server, client := net.Pipe()
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(client)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	done := make(chan bool)
+	go func() {
+		LineHandler(server)
+		done <- true
+	}()
+	// title and help
+	for i := 0; i < 9; i++ {
+		<-lines
+	}
+	a := <-ttts.Announce
+	assert.Equal(t, a.Status, ttt.StatusConnected)
+	ttts.ProcessAnnouncement(a)
+	assert.Equal(t, <-lines, "status: "+ttt.StatusConnected)
+	assert.Equal(t, <-lines, "token: "+a.Token)
+
+	client.Write([]byte("move 1\n"))
+	assert.Equal(t, <-lines, "error: usage: MOVE x y")
+
+	client.Write([]byte("join Adam\n"))
+	ttts.ProcessAnnouncement(<-ttts.Announce)
+	assert.Equal(t, <-lines, "status: "+ttt.StatusWait)
+	ttts.ProcessAnnouncement(<-ttts.Announce)
+	assert.Equal(t, <-lines, "ok")
+	assert.Equal(t, ttts.BenchPlayers.Len(), 1)
+
+	client.Write([]byte("quit\n"))
+	<-done
+	assert.Equal(t, ttts.BenchPlayers.Len(), 0)
+	client.Close()
+	tttsTeardown()
+}
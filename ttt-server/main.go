@@ -1,20 +1,146 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
 )
 
 func main() {
 	addr := flag.String("p", ":8080", "port")
+	lineAddr := flag.String("t", "", "port of the plain TCP line protocol")
+	dataDir := flag.String("d", "data", "directory to keep games in")
+	grace := flag.Duration("g", 30*time.Second,
+		"time given to rounds in progress on shutdown")
+	engine := flag.String("e", ttt.EngineDeepening,
+		"engine of expert bots, one of "+
+			strings.Join(ttt.EngineNames(), ", "))
+	think := flag.Duration("think", AIThinkTime,
+		"time bots may think about a move")
+	hints := flag.String("hints", ttt.HintsOff,
+		"hints in rated rounds: off, server or local")
+	node := flag.String("node", "",
+		"name of this node in a cluster, the host name by default")
+	brokerAddr := flag.String("broker", "",
+		"address of the broker shared with other nodes")
+	brokerListen := flag.String("broker-listen", "",
+		"address to serve a broker for other nodes on")
 	flag.Parse()
-	http.HandleFunc("/", WSHandler)
+	if _, err := ttt.NewEngine(*engine); err != nil {
+		glog.Exitln(err, *engine)
+	}
+	am.Engine = *engine
+	am.ThinkTime = *think
+	switch *hints {
+	case ttt.HintsOff, ttt.HintsServer, ttt.HintsLocal:
+		ttts.RatedHints = *hints
+	default:
+		glog.Exitln("unknown hints", *hints)
+	}
+	if err := os.MkdirAll(*dataDir, 0755); err != nil {
+		glog.Exitln(err)
+	}
+	archive, err := NewArchive(filepath.Join(*dataDir, "archive"))
+	if err != nil {
+		glog.Exitln(err)
+	}
+	ttts.Archive = archive
+	ttts.SnapshotPath = filepath.Join(*dataDir, "state.json")
+	snap, err := ReadSnapshot(ttts.SnapshotPath)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	var brokers net.Listener
+	if *brokerAddr != "" || *brokerListen != "" {
+		var broker Broker
+		if *brokerListen != "" {
+			mb := NewMemoryBroker()
+			brokers, err = net.Listen("tcp", *brokerListen)
+			if err != nil {
+				glog.Exitln(err)
+			}
+			fmt.Println("Broker is running at", *brokerListen)
+			go ServeBroker(brokers, mb)
+			broker = mb
+		} else if broker, err = DialBroker(*brokerAddr); err != nil {
+			glog.Exitln(err)
+		}
+		if *node == "" {
+			if *node, err = os.Hostname(); err != nil {
+				glog.Exitln(err)
+			}
+		}
+		bb := NewBrokerBackend(*node, broker, ttts.player)
+		if err := bb.Listen(ttts.HandleEnvelope); err != nil {
+			glog.Exitln(err)
+		}
+		ttts.Backend = bb
+	}
+	go ttts.Daemon()
+	go am.Dispatch()
+	if snap != nil {
+		ttts.Restore(snap)
+	}
+	http.HandleFunc("/", RootHandler)
 
-	fmt.Println("Server is running at", *addr)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
+	as, err := NewAsyncServer(filepath.Join(*dataDir, "async"))
+	if err != nil {
 		glog.Exitln(err)
 	}
+	http.Handle("/api/games", as)
+	http.Handle("/api/games/", as)
+	http.Handle("/api/archive", archive)
+	http.Handle("/api/archive/", archive)
+
+	var lines net.Listener
+	if *lineAddr != "" {
+		lines, err = net.Listen("tcp", *lineAddr)
+		if err != nil {
+			glog.Exitln(err)
+		}
+		fmt.Println("Line protocol is running at", *lineAddr)
+		go ServeLines(lines)
+	}
+
+	srv := &http.Server{Addr: *addr}
+	go func() {
+		fmt.Println("Server is running at", *addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			glog.Exitln(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	fmt.Println("Shutting down on", <-signals)
+	ctx, cancel := context.WithTimeout(context.Background(), *grace)
+	defer cancel()
+	// stop accepting connections, websockets are taken care of by ttts
+	if lines != nil {
+		lines.Close()
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- srv.Shutdown(ctx)
+	}()
+	ttts.Shutdown(ctx)
+	am.Shutdown()
+	if brokers != nil {
+		brokers.Close()
+	}
+	if err := <-closed; err != nil {
+		glog.Warningln(err)
+	}
+	glog.Flush()
 }
@@ -2,9 +2,14 @@ package main
 
 import (
 	"container/list"
+	"context"
+	"expvar"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"code.google.com/p/go-uuid/uuid"
 
@@ -17,6 +22,11 @@ const (
 	ReadBufferSize  int = 1024
 	WriteBufferSize int = 2048
 	BufferedChanLen int = 10
+
+	// How often a shutting down server checks the rounds in progress, and
+	// how long it waits for connections to close
+	ShutdownPoll      = 100 * time.Millisecond
+	ShutdownCloseWait = 2 * ttt.WriteWait
 )
 
 var ttts = InitTTTServer()
@@ -25,35 +35,79 @@ var ttts = InitTTTServer()
 var playerActions = make(chan ttt.PlayerAction, BufferedChanLen)
 var playerStatuses = make(chan ttt.PlayerStatus, BufferedChanLen)
 
+// Round-trip time in milliseconds of each connection, exported at
+// /debug/vars
+var latencies = expvar.NewMap("latency_ms")
+
 type Player struct {
 	WS      *websocket.Conn
+	Line    *LineConn
 	RoundID string
 	ID      string
 	Name    string
 	Score   int
+	// Sequence number and acknowledgement of the last action received
+	// on this connection
+	LastSeq int
+	LastAck *ttt.ActionAck
+	// Node the player is connected to, empty for players of this node
+	Node string
+	// Session token handed to the client to resume after a restart
+	Token string
+	AI    bool
+	// How well a bot plays
+	Level string
 }
 
 func (p *Player) repr() string {
 	return p.Name + " (" + p.ID + ")"
 }
 
+// Ping the client every interval until stop is closed. The pong
+// handler takes care of the read deadline and latency.
+func (p *Player) heartbeat(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			payload := strconv.FormatInt(t.UnixNano(), 10)
+			err := p.WS.WriteControl(websocket.PingMessage,
+				[]byte(payload), time.Now().Add(ttt.WriteWait))
+			if err != nil {
+				glog.Infoln("can not ping player", p.repr(), err)
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Keep the connection alive and record its round-trip time
+func (p *Player) handlePong(payload string) error {
+	p.WS.SetReadDeadline(time.Now().Add(ttt.PongWait))
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err == nil {
+		rtt := time.Since(time.Unix(0, sent))
+		latency := new(expvar.Int)
+		latency.Set(int64(rtt / time.Millisecond))
+		latencies.Set(p.ID, latency)
+	}
+	return nil
+}
+
 // Parse the action sent by a client
 func (p *Player) parseAction() {
 	for {
 		m := ttt.PlayerAction{}
-		p.WS.ReadJSON(&m)
-		switch m.Cmd {
-		case ttt.CmdQuit:
+		if err := p.WS.ReadJSON(&m); err != nil {
+			glog.Infoln("lost connection to player", p.repr(), err)
 			ttts.ProcessQuit(p)
 			return
-		case ttt.CmdJoin:
-			p.Name = m.PlayerName
-			ttts.ProcessJoin(p, false)
-		case ttt.CmdJoinAI:
-			p.Name = m.PlayerName
-			ttts.ProcessJoin(p, true)
-		case ttt.CmdMove:
-			ttts.Judge(&m)
+		}
+		if !ttts.ProcessAction(p, &m) {
+			return
 		}
 	}
 }
@@ -95,12 +149,72 @@ func (q *PlayersQueue) Remove(p *Player) {
 	}
 }
 
+// Waiting players, in order
+func (q *PlayersQueue) Players() []*Player {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	players := []*Player{}
+	for e := q.players.Front(); e != nil; e = e.Next() {
+		players = append(players, e.Value.(*Player))
+	}
+	return players
+}
+
+// Put a player in the place of another one. Return false if the other
+// one is not waiting.
+func (q *PlayersQueue) Replace(old, p *Player) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for e := q.players.Front(); e != nil; e = e.Next() {
+		if e.Value.(*Player) == old {
+			e.Value = p
+			return true
+		}
+	}
+	return false
+}
+
 type Round struct {
 	ID            string
 	CurrentPlayer *Player
 	NextPlayer    *Player
 	Winner        *Player
 	Grid          *ttt.Grid
+	Seq           int
+	// Seat mapping, the player who moves first plays X
+	XPlayer *Player
+	OPlayer *Player
+	// Whether hints are allowed, and how many each mark asked for
+	Hints  string
+	HintsX int
+	HintsO int
+}
+
+// Mark of a player in this round
+func (r *Round) markOf(p *Player) ttt.Mark {
+	if p.ID == "" {
+		return ttt.MarkEmpty
+	} else if r.XPlayer != nil && r.XPlayer.ID == p.ID {
+		return ttt.MarkX
+	} else if r.OPlayer != nil && r.OPlayer.ID == p.ID {
+		return ttt.MarkO
+	}
+	return ttt.MarkEmpty
+}
+
+// Copy of the grid as it is now
+func (r *Round) snapshot() *ttt.Grid {
+	grid := *r.Grid
+	return &grid
+}
+
+func (r *Round) getPlayer(id string) *Player {
+	if r.CurrentPlayer != nil && r.CurrentPlayer.ID == id {
+		return r.CurrentPlayer
+	} else if r.NextPlayer != nil && r.NextPlayer.ID == id {
+		return r.NextPlayer
+	}
+	return nil
 }
 
 // Switch turn in a matching round
@@ -110,6 +224,32 @@ func (r *Round) switchTurn() {
 	r.NextPlayer = temp
 }
 
+// Put a player in the seat of another one
+func (r *Round) replacePlayer(old, p *Player) {
+	seats := []**Player{&r.CurrentPlayer, &r.NextPlayer, &r.XPlayer,
+		&r.OPlayer}
+	for _, seat := range seats {
+		if *seat == old {
+			*seat = p
+		}
+	}
+}
+
+// The whole round as one of its players is to see it
+func (r *Round) stateFor(p *Player) *Announcement {
+	status := ttt.StatusWaitTurn
+	if p == r.CurrentPlayer {
+		status = ttt.StatusYourTurn
+	}
+	return &Announcement{
+		ToPlayer: *p,
+		VSPlayer: *r.getOtherPlayer(p),
+		Rd:       *r,
+		Status:   status,
+		GridSnap: r.snapshot(),
+	}
+}
+
 func (r *Round) getOtherPlayer(p *Player) *Player {
 	if r.CurrentPlayer != p && r.NextPlayer == p {
 		return r.CurrentPlayer
@@ -125,6 +265,12 @@ type Announcement struct {
 	VSPlayer Player
 	Rd       Round
 	Status   string
+	Move     *ttt.MoveEvent
+	GridSnap *ttt.Grid
+	Ack      *ttt.ActionAck
+	Notice   string
+	Token    string
+	Hint     *ttt.Position
 }
 
 func (ann *Announcement) repr() string {
@@ -144,27 +290,50 @@ func (ann *Announcement) toPlayerStatus() *ttt.PlayerStatus {
 	ps.RoundID = ann.Rd.ID
 	ps.PlayerID = ann.ToPlayer.ID
 	ps.PlayerScore = ann.ToPlayer.Score
+	ps.Mark = ann.Rd.markOf(&ann.ToPlayer)
 	if &ann.VSPlayer != nil {
 		ps.VSID = ann.VSPlayer.ID
 		ps.VSScore = ann.VSPlayer.Score
 		ps.VSName = ann.VSPlayer.Name
+		ps.VSMark = ann.Rd.markOf(&ann.VSPlayer)
 	}
 	ps.Status = ann.Status
-	if &ann.Rd != nil {
-		ps.GridSnap = ann.Rd.Grid
-	}
+	ps.Seq = ann.Rd.Seq
+	ps.Move = ann.Move
+	ps.GridSnap = ann.GridSnap
+	ps.Ack = ann.Ack
+	ps.Notice = ann.Notice
+	ps.Token = ann.Token
+	ps.Hints = ann.Rd.Hints
+	ps.Hint = ann.Hint
 	return &ps
 }
 
 type Group map[string]Round
 
 type TTTServer struct {
-	Players       *map[string]*Player
-	Groups        *Group
+	Players *map[string]*Player
+	Groups  *Group
+	// Guards Players, Groups and detached, used by the connections, the
+	// daemon and shutdown alike
+	lock          sync.Mutex
 	BenchPlayers  *PlayersQueue
 	WithAIPlayers chan *Player
+	Backend       Backend
 
 	Announce chan *Announcement // outgoing channel
+	closing  chan bool          // closed once shutting down
+
+	// File to save snapshots to, none if empty
+	SnapshotPath string
+	snapshotLock sync.Mutex
+	// Restored players waiting to resume, by session token
+	detached map[string]*Player
+	// Where rounds are logged, none if nil
+	Archive *Archive
+	// Hints allowed in rated rounds, those between two people. Rounds
+	// against bots always allow local hints.
+	RatedHints string
 }
 
 // Create a new round between 2 players.
@@ -183,29 +352,139 @@ func (ttts *TTTServer) createNewRound(p1, p2 *Player) Round {
 		NextPlayer:    nextPlayer,
 		Winner:        nil,
 		Grid:          &grid,
+		XPlayer:       currentPlayer,
+		OPlayer:       nextPlayer,
+		Hints:         ttt.HintsLocal,
+	}
+	if !p1.AI && !p2.AI {
+		r.Hints = ttts.RatedHints
 	}
 	currentPlayer.RoundID = r.ID
 	nextPlayer.RoundID = r.ID
-	(*ttts.Groups)[r.ID] = r
+	ttts.saveRound(r)
+	if err := ttts.Backend.ClaimRound(r.ID); err != nil {
+		glog.Warningln("can not claim round", r.ID, err)
+	}
+	ttts.record(&RoundEvent{
+		Type:    EventCreated,
+		RoundID: r.ID,
+		X:       &ArchivedPlayer{ID: r.XPlayer.ID, Name: r.XPlayer.Name},
+		O:       &ArchivedPlayer{ID: r.OPlayer.ID, Name: r.OPlayer.Name},
+	})
 	ttts.Announce <- &Announcement{
 		ToPlayer: *r.CurrentPlayer,
 		VSPlayer: *r.NextPlayer,
 		Rd:       r,
 		Status:   ttt.StatusYourTurn,
+		GridSnap: r.snapshot(),
 	}
 	ttts.Announce <- &Announcement{
 		ToPlayer: *r.NextPlayer,
 		VSPlayer: *r.CurrentPlayer,
 		Rd:       r,
 		Status:   ttt.StatusWaitTurn,
+		GridSnap: r.snapshot(),
 	}
 	glog.Infoln("new round between", p1.repr(), "and", p2.repr())
 	return r
 }
 
-func (ttts *TTTServer) ProcessJoin(p *Player, withAI bool) {
-	(*ttts.Players)[p.ID] = p
-	glog.Infoln("total players", len((*ttts.Players)))
+// Process an action of the player and acknowledge it. Retransmitted
+// actions are acknowledged again without being processed twice.
+// Return false once the player quits.
+func (ttts *TTTServer) ProcessAction(p *Player, m *ttt.PlayerAction) bool {
+	if ttts.forward(p, m) {
+		return true
+	}
+	if m.Seq != 0 && m.Seq <= p.LastSeq {
+		if p.LastAck != nil && p.LastAck.Seq == m.Seq {
+			ttts.Announce <- &Announcement{
+				ToPlayer: *p,
+				Ack:      p.LastAck,
+			}
+		}
+		return true
+	}
+	reason := ""
+	switch m.Cmd {
+	case ttt.CmdQuit:
+		ttts.ProcessQuit(p)
+		return false
+	case ttt.CmdJoin, ttt.CmdJoinAI:
+		if ttts.isClosing() {
+			reason = ttt.ReasonShuttingDown
+			break
+		}
+		if !ttt.IsValidLevel(m.Level) {
+			reason = ttt.ReasonUnknownLevel
+			break
+		}
+		p.Name = m.PlayerName
+		ttts.ProcessJoin(p, m.Cmd == ttt.CmdJoinAI, m.Level)
+	case ttt.CmdMove:
+		reason = ttts.Judge(m)
+	case ttt.CmdResync:
+		reason = ttts.ProcessResync(m)
+	case ttt.CmdResume:
+		reason = ttts.ProcessResume(p, m.Token)
+	case ttt.CmdChat:
+		reason = ttts.ProcessChat(m)
+	case ttt.CmdHint:
+		reason = ttts.ProcessHint(m)
+	default:
+		reason = ttt.ReasonUnknownCmd
+	}
+	if m.Seq != 0 {
+		p.LastSeq = m.Seq
+		p.LastAck = &ttt.ActionAck{
+			Seq:    m.Seq,
+			OK:     reason == "",
+			Reason: reason,
+		}
+		ttts.Announce <- &Announcement{
+			ToPlayer: *p,
+			Ack:      p.LastAck,
+		}
+	}
+	return true
+}
+
+// Pass an action on a round owned by another node to that node, which
+// acknowledges it. Return false if the action is for this node.
+func (ttts *TTTServer) forward(p *Player, m *ttt.PlayerAction) bool {
+	if m.Cmd != ttt.CmdMove && m.Cmd != ttt.CmdResync &&
+		m.Cmd != ttt.CmdChat && m.Cmd != ttt.CmdHint {
+		return false
+	}
+	owner := ttts.remoteOwner(m.RoundID)
+	if owner == "" {
+		return false
+	}
+	if err := ttts.Backend.Send(owner, &Envelope{Action: m}); err != nil {
+		glog.Warningln("can not forward action of", p.repr(), "to", owner, err)
+	}
+	return true
+}
+
+// Node owning a round if that is another node, empty otherwise
+func (ttts *TTTServer) remoteOwner(id string) string {
+	if id == "" {
+		return ""
+	} else if ttts.round(id).ID != "" {
+		return ""
+	}
+	owner, err := ttts.Backend.RoundOwner(id)
+	if err != nil || owner == ttts.Backend.Node() {
+		return ""
+	}
+	return owner
+}
+
+// Put a player in the waiting queue, or in a round with a bot of the
+// given level
+func (ttts *TTTServer) ProcessJoin(p *Player, withAI bool, level string) {
+	ttts.addPlayer(p)
+	glog.Infoln("total players", ttts.playerCount())
 	ttts.Announce <- &Announcement{
 		ToPlayer: *p,
 		VSPlayer: Player{},
@@ -213,33 +492,48 @@ func (ttts *TTTServer) ProcessJoin(p *Player, withAI bool) {
 		Status:   ttt.StatusWait,
 	}
 	if withAI {
+		if level == "" {
+			level = ttt.LevelExpert
+		}
 		aip := &Player{
 			ID:    uuid.New(),
-			Name:  BotNames[ttt.RandInt(len(BotNames))],
+			Name:  BotNames[ttt.RandInt(len(BotNames))] + " (" + level + ")",
 			Score: ttt.RandInt(100),
+			AI:    true,
+			Level: level,
 		}
 		ttts.createNewRound(p, aip)
 		glog.Infoln("deploying AI player")
-		am.NewAIPlayer(aip.ID)
+		am.NewAIPlayer(aip.ID, level)
 	} else {
-		ttts.BenchPlayers.Remove(p)
-		ttts.BenchPlayers.Push(p)
-		glog.Infoln("waiting list size", ttts.BenchPlayers.Len())
-		if ttts.BenchPlayers.Len() > 1 {
-			p1 := ttts.BenchPlayers.Pop()
-			p2 := ttts.BenchPlayers.Pop()
+		ttts.Backend.Dequeue(p)
+		if err := ttts.Backend.Enqueue(p); err != nil {
+			glog.Warningln("can not queue player", p.repr(), err)
+			return
+		}
+		glog.Infoln("waiting list size", ttts.Backend.QueueLen())
+		p1, p2, err := ttts.Backend.Match()
+		if err != nil {
+			glog.Warningln("can not match players", err)
+		} else if p1 != nil && p2 != nil {
 			ttts.createNewRound(p1, p2)
 		}
 	}
 }
 
 func (ttts *TTTServer) ProcessQuit(p *Player) {
-	delete((*ttts.Players), p.ID)
+	ttts.removePlayer(p.ID)
 	if p.RoundID != "" {
-		rd := (*ttts.Groups)[p.RoundID]
+		rd := ttts.round(p.RoundID)
 		// end the round and put the other into waiting queue
 		if rd != (Round{}) {
-			delete(*ttts.Groups, p.RoundID)
+			ttts.EndRound(p.RoundID)
+			ttts.record(&RoundEvent{
+				Type:     EventResult,
+				RoundID:  rd.ID,
+				PlayerID: p.ID,
+				Result:   ResultLeft,
+			})
 			vs := rd.getOtherPlayer(p)
 			ttts.Announce <- &Announcement{
 				ToPlayer: *vs,
@@ -247,80 +541,505 @@ func (ttts *TTTServer) ProcessQuit(p *Player) {
 				Rd:       Round{},
 				Status:   ttt.StatusOtherLeft,
 			}
+		} else if owner := ttts.remoteOwner(p.RoundID); owner != "" {
+			ttts.Backend.Send(owner, &Envelope{Action: &ttt.PlayerAction{
+				RoundID:  p.RoundID,
+				PlayerID: p.ID,
+				Cmd:      ttt.CmdQuit,
+			}})
 		}
-	} else {
-		ttts.BenchPlayers.Remove(p)
 	}
+	ttts.Backend.Dequeue(p)
 	glog.Infoln("close connection for player", p.repr())
 	if p.WS != nil {
 		p.WS.Close()
 	}
+	if p.Line != nil {
+		p.Line.Close()
+	}
 }
 
 func (ttts *TTTServer) ProcessAnnouncement(a *Announcement) {
 	ps := a.toPlayerStatus()
 	glog.Infoln("announce to", a.ToPlayer.repr(), ps.Repr())
-	if a.ToPlayer.WS != nil {
-		a.ToPlayer.WS.WriteJSON(ps)
+	if a.ToPlayer.Node != "" {
+		err := ttts.Backend.Send(a.ToPlayer.Node,
+			&Envelope{To: a.ToPlayer.ID, Status: ps})
+		if err != nil {
+			glog.Warningln("can not reach node", a.ToPlayer.Node, err)
+		}
+	} else {
+		a.ToPlayer.deliver(ps)
+		if a.Status == ttt.StatusShutdown {
+			a.ToPlayer.hangUp(a.Status)
+		}
+	}
+}
+
+// Write a status to the connection of a player of this node
+func (p *Player) deliver(ps *ttt.PlayerStatus) {
+	if p.WS != nil {
+		p.WS.WriteJSON(ps)
+	} else if p.Line != nil {
+		p.Line.WriteStatus(ps)
+	} else if p.AI {
+		// bots care about nothing but the game
+		if ps.Status != "" {
+			playerStatuses <- *ps
+		}
 	} else {
-		playerStatuses <- *ps
+		glog.Infoln("drop status for detached player", p.repr())
+	}
+}
+
+// Close the connection of a player, telling websocket clients why.
+// The read loop of the connection ends and the player quits.
+func (p *Player) hangUp(reason string) {
+	if p.WS != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+		p.WS.WriteControl(websocket.CloseMessage, msg,
+			time.Now().Add(ttt.WriteWait))
+		// give the client a moment to answer the close frame
+		p.WS.SetReadDeadline(time.Now().Add(ttt.WriteWait))
+	} else if p.Line != nil {
+		p.Line.Close()
+	}
+}
+
+// Handle an envelope from another node
+func (ttts *TTTServer) HandleEnvelope(e *Envelope) {
+	glog.Infoln("envelope", e.repr())
+	if e.Status != nil {
+		p := ttts.player(e.To)
+		if p == nil {
+			glog.Infoln("drop status for unknown player", e.To)
+			return
+		}
+		// acknowledgements carry nothing about the round
+		if e.Status.Status != "" {
+			p.RoundID = e.Status.RoundID
+			p.Score = e.Status.PlayerScore
+		}
+		p.deliver(e.Status)
+	}
+	if e.Action != nil {
+		rd := ttts.round(e.Action.RoundID)
+		p := rd.getPlayer(e.Action.PlayerID)
+		if p == nil {
+			glog.Infoln("drop action for unknown round", e.Action.RoundID)
+			return
+		}
+		ttts.ProcessAction(p, e.Action)
 	}
+}
 
+// Check if a move can be made in a round. Return the reason if not.
+func (r *Round) validateMove(m *ttt.PlayerAction) string {
+	if r.ID == "" {
+		return ttt.ReasonUnknownRound
+	} else if r.CurrentPlayer.ID != m.PlayerID {
+		return ttt.ReasonNotYourTurn
+	} else if !ttt.IsValidPosition(m.Pos) {
+		return ttt.ReasonInvalidPosition
+	} else if r.Grid.Get(m.Pos) != ttt.MarkEmpty {
+		return ttt.ReasonCellTaken
+	}
+	return ""
 }
 
-func (ttts *TTTServer) Judge(m *ttt.PlayerAction) {
+// Judge a move. Return the reason if the move is rejected.
+func (ttts *TTTServer) Judge(m *ttt.PlayerAction) string {
+	ttts.lock.Lock()
 	rd := (*ttts.Groups)[m.RoundID]
-	if rd.ID == "" || rd.CurrentPlayer.ID != m.PlayerID {
-		glog.Infoln("Invalid move for player", rd.CurrentPlayer.repr())
-		return
+	if reason := rd.validateMove(m); reason != "" {
+		ttts.lock.Unlock()
+		glog.Infoln("Invalid move for player", m.PlayerID, reason)
+		return reason
 	}
 	currentUserStatus := ""
 	nextUserStatus := ""
+	over := true
+	mark := rd.markOf(rd.CurrentPlayer)
 	// Switch turn no matter what
 	rd.switchTurn()
-	if rd.Grid.HasSameMarksInRows(m.Pos, m.PlayerID) {
+	rd.Seq++
+	if rd.Grid.HasSameMarksInRows(m.Pos, mark) {
 		rd.Winner = rd.NextPlayer
 		rd.CurrentPlayer.Score -= ttt.Score
 		rd.NextPlayer.Score += ttt.Score
-		ttts.EndRound(m.RoundID)
+		delete(*ttts.Groups, m.RoundID)
 		currentUserStatus = ttt.StatusLoss
 		nextUserStatus = ttt.StatusWin
 	} else if rd.Grid.IsFull() {
-		ttts.EndRound(m.RoundID)
+		delete(*ttts.Groups, m.RoundID)
 		currentUserStatus = ttt.StatusTie
 		nextUserStatus = ttt.StatusTie
 	} else {
 		(*ttts.Groups)[m.RoundID] = rd
 		currentUserStatus = ttt.StatusYourTurn
 		nextUserStatus = ttt.StatusWaitTurn
+		over = false
+	}
+	// Send the whole grid once in a while and when the round is over,
+	// so that clients recover from lost moves
+	var snap *ttt.Grid
+	if over || rd.Seq%ttt.SnapshotInterval == 0 {
+		snap = rd.snapshot()
+	}
+	current, next := *rd.CurrentPlayer, *rd.NextPlayer
+	ttts.lock.Unlock()
+	if over {
+		ttts.releaseRound(m.RoundID)
+	}
+	move := &ttt.MoveEvent{
+		Seq:  rd.Seq,
+		Pos:  m.Pos,
+		Mark: mark,
+	}
+	ttts.record(&RoundEvent{
+		Type:     EventMove,
+		RoundID:  rd.ID,
+		PlayerID: m.PlayerID,
+		Move:     move,
+	})
+	if nextUserStatus == ttt.StatusWin {
+		ttts.record(&RoundEvent{
+			Type:    EventResult,
+			RoundID: rd.ID,
+			Result:  ResultWin,
+			Winner:  mark,
+		})
+	} else if nextUserStatus == ttt.StatusTie {
+		ttts.record(&RoundEvent{
+			Type:    EventResult,
+			RoundID: rd.ID,
+			Result:  ResultTie,
+		})
 	}
 	ttts.Announce <- &Announcement{
-		ToPlayer: *rd.CurrentPlayer,
-		VSPlayer: *rd.NextPlayer,
+		ToPlayer: current,
+		VSPlayer: next,
 		Rd:       rd,
 		Status:   currentUserStatus,
+		Move:     move,
+		GridSnap: snap,
 	}
 	ttts.Announce <- &Announcement{
-		ToPlayer: *rd.NextPlayer,
-		VSPlayer: *rd.CurrentPlayer,
+		ToPlayer: next,
+		VSPlayer: current,
 		Rd:       rd,
 		Status:   nextUserStatus,
+		Move:     move,
+		GridSnap: snap,
+	}
+	return ""
+}
+
+// Send a full snapshot of the round to a player who missed some moves
+func (ttts *TTTServer) ProcessResync(m *ttt.PlayerAction) string {
+	ttts.lock.Lock()
+	rd := (*ttts.Groups)[m.RoundID]
+	p := rd.getPlayer(m.PlayerID)
+	var a *Announcement
+	if p != nil {
+		a = rd.stateFor(p)
+	}
+	ttts.lock.Unlock()
+	if rd.ID == "" {
+		glog.Infoln("Can not resync unknown round", m.RoundID)
+		return ttt.ReasonUnknownRound
+	} else if a == nil {
+		glog.Infoln("Can not resync player", m.PlayerID, "in round", rd.ID)
+		return ttt.ReasonUnknownRound
+	}
+	ttts.Announce <- a
+	return ""
+}
+
+// Pass a chat message on to the other player of the round
+func (ttts *TTTServer) ProcessChat(m *ttt.PlayerAction) string {
+	rd := ttts.round(m.RoundID)
+	p := rd.getPlayer(m.PlayerID)
+	if p == nil {
+		return ttt.ReasonUnknownRound
+	}
+	text := strings.TrimSpace(m.Text)
+	if text == "" {
+		return ttt.ReasonEmptyText
+	} else if utf8.RuneCountInString(text) > ttt.ChatLengthLimit {
+		text = string([]rune(text)[:ttt.ChatLengthLimit])
+	}
+	ttts.record(&RoundEvent{
+		Type:     EventChat,
+		RoundID:  rd.ID,
+		PlayerID: p.ID,
+		Text:     text,
+	})
+	ttts.Announce <- &Announcement{
+		ToPlayer: *rd.getOtherPlayer(p),
+		Notice:   p.Name + ": " + text,
+	}
+	return ""
+}
+
+// Count a hint asked for by the player to move, if the round allows
+// hints, and send it unless the client worked it out itself
+func (ttts *TTTServer) ProcessHint(m *ttt.PlayerAction) string {
+	ttts.lock.Lock()
+	rd := (*ttts.Groups)[m.RoundID]
+	p := rd.getPlayer(m.PlayerID)
+	reason := ""
+	if p == nil {
+		reason = ttt.ReasonUnknownRound
+	} else if rd.Hints != ttt.HintsLocal && rd.Hints != ttt.HintsServer {
+		reason = ttt.ReasonNoHints
+	} else if p != rd.CurrentPlayer {
+		reason = ttt.ReasonNotYourTurn
+	}
+	if reason != "" {
+		ttts.lock.Unlock()
+		return reason
+	}
+	mark := rd.markOf(p)
+	if mark == ttt.MarkX {
+		rd.HintsX++
+	} else {
+		rd.HintsO++
+	}
+	(*ttts.Groups)[rd.ID] = rd
+	player := *p
+	g := ttt.Game{CurrentPlayer: mark, NextPlayer: mark.Other(),
+		Grd: *rd.Grid}
+	ttts.lock.Unlock()
+	ttts.record(&RoundEvent{
+		Type:     EventHint,
+		RoundID:  rd.ID,
+		PlayerID: player.ID,
+	})
+	if rd.Hints == ttt.HintsLocal {
+		return ""
+	}
+	hint := g.Hint()
+	ttts.Announce <- &Announcement{
+		ToPlayer: player,
+		Rd:       rd,
+		Hint:     &hint,
+		Notice:   "Hint: " + hint.Notation(),
+	}
+	return ""
+}
+
+// Log an event of a round, if rounds are archived
+func (ttts *TTTServer) record(e *RoundEvent) {
+	if ttts.Archive == nil {
+		return
+	}
+	if err := ttts.Archive.Append(e); err != nil {
+		glog.Warningln("can not archive round", e.RoundID, err)
 	}
 }
 
 func (ttts *TTTServer) EndRound(r string) {
+	ttts.lock.Lock()
 	delete(*ttts.Groups, r)
+	ttts.lock.Unlock()
+	ttts.releaseRound(r)
+}
+
+// Let other nodes know the round is no longer owned by this one
+func (ttts *TTTServer) releaseRound(r string) {
+	if err := ttts.Backend.ReleaseRound(r); err != nil {
+		glog.Warningln("can not release round", r, err)
+	}
+}
+
+// A player connected to this node, nil if there is none
+func (ttts *TTTServer) player(id string) *Player {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	return (*ttts.Players)[id]
+}
+
+func (ttts *TTTServer) addPlayer(p *Player) {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	(*ttts.Players)[p.ID] = p
+}
+
+func (ttts *TTTServer) removePlayer(id string) {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	delete(*ttts.Players, id)
+}
+
+func (ttts *TTTServer) playerCount() int {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	return len(*ttts.Players)
+}
+
+// The players connected to this node, in no particular order
+func (ttts *TTTServer) playerList() []*Player {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	players := []*Player{}
+	for _, p := range *ttts.Players {
+		players = append(players, p)
+	}
+	return players
+}
+
+// A round in progress on this node, the zero Round if there is none
+func (ttts *TTTServer) round(id string) Round {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	return (*ttts.Groups)[id]
+}
+
+func (ttts *TTTServer) saveRound(rd Round) {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	(*ttts.Groups)[rd.ID] = rd
+}
+
+func (ttts *TTTServer) roundCount() int {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	return len(*ttts.Groups)
+}
+
+// The rounds in progress on this node, in no particular order
+func (ttts *TTTServer) roundList() []Round {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	rounds := []Round{}
+	for _, rd := range *ttts.Groups {
+		rounds = append(rounds, rd)
+	}
+	return rounds
+}
+
+// Register a new connection
+func (ttts *TTTServer) Connect(p *Player) {
+	p.Token = uuid.New()
+	ttts.addPlayer(p)
+	ttts.Announce <- &Announcement{
+		ToPlayer: *p,
+		VSPlayer: Player{},
+		Rd:       Round{},
+		Status:   ttt.StatusConnected,
+		Token:    p.Token,
+	}
+}
+
+func (ttts *TTTServer) isClosing() bool {
+	select {
+	case <-ttts.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop taking new players and let the rounds in progress finish until
+// ctx is done. Rounds still going on then are saved in a snapshot, or
+// aborted if snapshots are off or fail. Everyone is told and
+// disconnected at last.
+func (ttts *TTTServer) Shutdown(ctx context.Context) {
+	close(ttts.closing)
+	players := ttts.playerList()
+	glog.Infoln("shutting down with", ttts.roundCount(), "rounds and",
+		len(players), "players")
+	for _, p := range players {
+		ttts.Announce <- &Announcement{ToPlayer: *p, Notice: ttt.NoticeShutdown}
+	}
+
+	ticker := time.NewTicker(ShutdownPoll)
+	defer ticker.Stop()
+wait:
+	for ttts.roundCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	if ttts.SnapshotPath == "" {
+		ttts.abortRounds()
+	} else if err := ttts.saveSnapshot(); err != nil {
+		glog.Warningln("can not save snapshot", err)
+		ttts.abortRounds()
+	} else {
+		ttts.dropRounds()
+	}
+
+	for _, p := range players {
+		ttts.Announce <- &Announcement{
+			ToPlayer: *p,
+			Status:   ttt.StatusShutdown,
+		}
+	}
+	timeout := time.After(ShutdownCloseWait)
+	for ttts.playerCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			glog.Infoln(ttts.playerCount(), "players did not hang up")
+			return
+		}
+	}
+}
+
+// End the rounds in progress and tell their players
+func (ttts *TTTServer) abortRounds() {
+	for _, rd := range ttts.roundList() {
+		glog.Infoln("abort round", rd.ID)
+		ttts.EndRound(rd.ID)
+		ttts.record(&RoundEvent{
+			Type:    EventResult,
+			RoundID: rd.ID,
+			Result:  ResultAborted,
+		})
+		for _, p := range []*Player{rd.CurrentPlayer, rd.NextPlayer} {
+			ttts.Announce <- &Announcement{
+				ToPlayer: *p,
+				Status:   ttt.StatusAborted,
+			}
+		}
+	}
+}
+
+// Forget the rounds saved in a snapshot without ending them, so that
+// they go on after a restart whoever hangs up now
+func (ttts *TTTServer) dropRounds() {
+	ttts.lock.Lock()
+	defer ttts.lock.Unlock()
+	for id := range *ttts.Groups {
+		delete(*ttts.Groups, id)
+	}
 }
 
 func (ttts *TTTServer) Daemon() {
+	var snapshots <-chan time.Time
+	if ttts.SnapshotPath != "" {
+		ticker := time.NewTicker(StateSaveInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
 	for {
 		select {
 		case a := <-ttts.Announce:
 			ttts.ProcessAnnouncement(a)
 		case p := <-ttts.WithAIPlayers:
-			ttts.ProcessJoin(p, false)
+			ttts.ProcessJoin(p, false, "")
 		case a := <-playerActions:
-			ttts.Judge(&a)
+			if a.Cmd == ttt.CmdResync {
+				ttts.ProcessResync(&a)
+			} else {
+				ttts.Judge(&a)
+			}
+		case <-snapshots:
+			ttts.autoSnapshot()
 		}
 	}
 }
@@ -339,10 +1058,12 @@ func InitTTTServer() *TTTServer {
 		players: list.New(),
 		lock:    sync.Mutex{},
 	}
+	ttts.Backend = &MemoryBackend{Queue: ttts.BenchPlayers}
 	ttts.WithAIPlayers = make(chan *Player, BufferedChanLen)
 	ttts.Announce = make(chan *Announcement, BufferedChanLen)
 	ttts.Groups = &group
-	go ttts.Daemon()
+	ttts.closing = make(chan bool)
+	ttts.RatedHints = ttt.HintsOff
 	return &ttts
 }
 
@@ -351,12 +1072,13 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
-	p := &Player{ws, "", uuid.New(), "", 0}
-	ttts.Announce <- &Announcement{
-		ToPlayer: *p,
-		VSPlayer: Player{},
-		Rd:       Round{},
-		Status:   ttt.StatusConnected,
-	}
+	p := &Player{WS: ws, ID: uuid.New()}
+	ws.SetReadDeadline(time.Now().Add(ttt.PongWait))
+	ws.SetPongHandler(p.handlePong)
+	stop := make(chan bool)
+	go p.heartbeat(ttt.PingInterval, stop)
+	ttts.Connect(p)
 	p.parseAction()
+	close(stop)
+	latencies.Delete(p.ID)
 }
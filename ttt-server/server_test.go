@@ -1,14 +1,56 @@
 package main
 
 import (
+	"bufio"
 	"container/list"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/wujiang/tic-tac-toe"
 )
 
+func TestPlayerHeartbeat(t *testing.T) {
+	done := make(chan bool)
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ws, err := upgrader.Upgrade(w, r, nil)
+			assert.Nil(t, err)
+			p := &Player{WS: ws, ID: "player-heartbeat"}
+			ws.SetPongHandler(p.handlePong)
+			stop := make(chan bool)
+			go p.heartbeat(10*time.Millisecond, stop)
+			// pongs are handled while reading
+			ws.ReadMessage()
+			close(stop)
+			done <- true
+		}))
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{})
+	assert.Nil(t, err)
+	// the default ping handler answers while reading
+	go ws.ReadMessage()
+	for i := 0; i < 100 && latencies.Get("player-heartbeat") == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotNil(t, latencies.Get("player-heartbeat"))
+	ws.Close()
+	<-done
+	latencies.Delete("player-heartbeat")
+}
+
 func TestPlayersQueuePush(t *testing.T) {
 	pq := PlayersQueue{
 		players: list.New(),
@@ -89,6 +131,24 @@ func TestRoundgetOtherPlayer(t *testing.T) {
 	assert.Equal(t, rd.getOtherPlayer(player1), player2)
 }
 
+func TestRoundmarkOf(t *testing.T) {
+	player1 := &Player{
+		ID: "Adam",
+	}
+	player2 := &Player{
+		ID: "John",
+	}
+	rd := &Round{
+		CurrentPlayer: player1,
+		NextPlayer:    player2,
+		XPlayer:       player1,
+		OPlayer:       player2,
+	}
+	assert.Equal(t, rd.markOf(player1), ttt.MarkX)
+	assert.Equal(t, rd.markOf(&Player{ID: "John"}), ttt.MarkO)
+	assert.Equal(t, rd.markOf(&Player{}), ttt.MarkEmpty)
+}
+
 func TestAnnouncementtoPlayerStatus(t *testing.T) {
 	player1 := Player{
 		ID: "Adam",
@@ -108,7 +168,6 @@ func TestAnnouncementtoPlayerStatus(t *testing.T) {
 		PlayerID:    ann.ToPlayer.ID,
 		PlayerScore: ann.ToPlayer.Score,
 		Status:      ann.Status,
-		GridSnap:    ann.Rd.Grid,
 	}
 	ps := ann.toPlayerStatus()
 	assert.Equal(t, *ps, expected)
@@ -125,10 +184,23 @@ func TestAnnouncementtoPlayerStatus(t *testing.T) {
 		VSName:      ann.VSPlayer.Name,
 		VSScore:     ann.VSPlayer.Score,
 		Status:      ann.Status,
-		GridSnap:    ann.Rd.Grid,
 	}
 	ps = ann.toPlayerStatus()
 	assert.Equal(t, *ps, expected)
+
+	grid := ttt.Grid{}
+	ann.Rd.XPlayer = &player1
+	ann.Rd.OPlayer = &player2
+	ann.Move = &ttt.MoveEvent{Seq: 1, Pos: ttt.Position{}, Mark: ttt.MarkX}
+	ann.GridSnap = &grid
+	ann.Rd.Seq = 1
+	expected.Seq = 1
+	expected.Mark = ttt.MarkX
+	expected.VSMark = ttt.MarkO
+	expected.Move = ann.Move
+	expected.GridSnap = &grid
+	ps = ann.toPlayerStatus()
+	assert.Equal(t, *ps, expected)
 }
 
 func tttsTeardown() {
@@ -139,12 +211,17 @@ func tttsTeardown() {
 		players: list.New(),
 		lock:    sync.Mutex{},
 	}
+	ttts.Backend = &MemoryBackend{Queue: ttts.BenchPlayers}
 	ttts.WithAIPlayers = make(chan *Player, BufferedChanLen)
 	ttts.Announce = make(chan *Announcement, BufferedChanLen)
 	ttts.Groups = &group
+	ttts.closing = make(chan bool)
+	ttts.detached = nil
 
+	am.Shutdown()
 	aiPlayers := make(map[string]*AIPlayer)
 	am.AIPlayers = &aiPlayers
+	am.done = make(chan bool)
 }
 
 func TestTTTScreateNewRound(t *testing.T) {
@@ -172,19 +249,50 @@ func TestTTTSProcessJoinAI(t *testing.T) {
 		ID:   "player-1",
 		Name: "Adam",
 	}
-	ttts.ProcessJoin(player1, true)
+	ttts.ProcessJoin(player1, true, "")
 	assert.Equal(t, len(*am.AIPlayers), 1)
 	assert.Equal(t, len(*ttts.Players), 1)
 	assert.Equal(t, len(*ttts.Groups), 1)
 	tttsTeardown()
 }
 
+func TestTTTSProcessJoinAILevel(t *testing.T) {
+	player1 := &Player{
+		ID:   "player-1",
+		Name: "Adam",
+	}
+	ttts.ProcessJoin(player1, true, ttt.LevelBeginner)
+	rd := (*ttts.Groups)[player1.RoundID]
+	bot := rd.getOtherPlayer(player1)
+	assert.True(t, bot.AI)
+	assert.Equal(t, bot.Level, ttt.LevelBeginner)
+	assert.True(t, strings.HasSuffix(bot.Name, " (beginner)"))
+	assert.Equal(t, (*am.AIPlayers)[bot.ID].Level, ttt.LevelBeginner)
+	tttsTeardown()
+
+	ttts.ProcessJoin(player1, true, "")
+	rd = (*ttts.Groups)[player1.RoundID]
+	assert.Equal(t, rd.getOtherPlayer(player1).Level, ttt.LevelExpert)
+	tttsTeardown()
+
+	assert.True(t, ttts.ProcessAction(player1, &ttt.PlayerAction{
+		PlayerName: "Adam",
+		Cmd:        ttt.CmdJoinAI,
+		Level:      "grandmaster",
+		Seq:        1,
+	}))
+	a := <-ttts.Announce
+	assert.Equal(t, a.Ack.Reason, ttt.ReasonUnknownLevel)
+	assert.Equal(t, len(*ttts.Groups), 0)
+	tttsTeardown()
+}
+
 func TestTTTSProcessJoinSingle(t *testing.T) {
 	player1 := &Player{
 		ID:   "player-1",
 		Name: "Adam",
 	}
-	ttts.ProcessJoin(player1, false)
+	ttts.ProcessJoin(player1, false, "")
 	assert.Equal(t, len(*am.AIPlayers), 0)
 	assert.Equal(t, len(*ttts.Players), 1)
 	assert.Equal(t, len(*ttts.Groups), 0)
@@ -197,12 +305,12 @@ func TestTTTSProcessJoin(t *testing.T) {
 		ID:   "player-1",
 		Name: "Adam",
 	}
-	ttts.ProcessJoin(player1, false)
+	ttts.ProcessJoin(player1, false, "")
 	player2 := &Player{
 		ID:   "player-2",
 		Name: "John",
 	}
-	ttts.ProcessJoin(player2, false)
+	ttts.ProcessJoin(player2, false, "")
 	assert.Equal(t, len(*am.AIPlayers), 0)
 	assert.Equal(t, len(*ttts.Players), 2)
 	assert.Equal(t, len(*ttts.Groups), 1)
@@ -215,12 +323,12 @@ func TestTTTSProcessQuit(t *testing.T) {
 		ID:   "player-1",
 		Name: "Adam",
 	}
-	ttts.ProcessJoin(player1, false)
+	ttts.ProcessJoin(player1, false, "")
 	player2 := &Player{
 		ID:   "player-2",
 		Name: "John",
 	}
-	ttts.ProcessJoin(player2, false)
+	ttts.ProcessJoin(player2, false, "")
 	ttts.ProcessQuit(player1)
 	assert.Equal(t, len(*am.AIPlayers), 0)
 	assert.Equal(t, len(*ttts.Players), 1)
@@ -228,3 +336,337 @@ func TestTTTSProcessQuit(t *testing.T) {
 	assert.Equal(t, ttts.BenchPlayers.Len(), 0)
 	tttsTeardown()
 }
+
+func TestTTTSProcessQuitShuttingDown(t *testing.T) {
+	player1 := &Player{
+		ID:   "player-1",
+		Name: "Adam",
+	}
+	player2 := &Player{
+		ID:   "player-2",
+		Name: "John",
+	}
+	ttts.createNewRound(player1, player2)
+	<-ttts.Announce
+	<-ttts.Announce
+	close(ttts.closing)
+	// a round left while shutting down ends as usual
+	ttts.ProcessQuit(player1)
+	assert.Equal(t, len(*ttts.Groups), 0)
+	a := <-ttts.Announce
+	assert.Equal(t, a.ToPlayer.ID, player2.ID)
+	assert.Equal(t, a.Status, ttt.StatusOtherLeft)
+	tttsTeardown()
+}
+
+func TestTTTSShutdownSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ttt-shutdown")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ttts.SnapshotPath = filepath.Join(dir, "state.json")
+	player1 := &Player{
+		ID:   "player-1",
+		Name: "Adam",
+	}
+	player2 := &Player{
+		ID:   "player-2",
+		Name: "John",
+	}
+	rd := ttts.createNewRound(player1, player2)
+	<-ttts.Announce
+	<-ttts.Announce
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ttts.Shutdown(ctx)
+	snap, err := ReadSnapshot(ttts.SnapshotPath)
+	assert.Nil(t, err)
+	assert.Equal(t, len(snap.Rounds), 1)
+	assert.Equal(t, snap.Rounds[0].ID, rd.ID)
+	// hanging up afterwards leaves the saved round alone
+	ttts.ProcessQuit(player1)
+	assert.Equal(t, len(ttts.Announce), 0)
+	ttts.SnapshotPath = ""
+	tttsTeardown()
+}
+
+func TestTTTSJudgeMoveEvents(t *testing.T) {
+	player1 := &Player{
+		ID:   "player-1",
+		Name: "Adam",
+	}
+	player2 := &Player{
+		ID:   "player-2",
+		Name: "John",
+	}
+	rd := ttts.createNewRound(player1, player2)
+	a1 := <-ttts.Announce
+	a2 := <-ttts.Announce
+	assert.NotNil(t, a1.GridSnap)
+	assert.NotNil(t, a2.GridSnap)
+
+	moves := []ttt.Position{{X: 0, Y: 0}, {X: 1, Y: 1}, {X: 2, Y: 2},
+		{X: 0, Y: 2}}
+	for i, pos := range moves {
+		rd = (*ttts.Groups)[rd.ID]
+		ttts.Judge(&ttt.PlayerAction{
+			RoundID:  rd.ID,
+			PlayerID: rd.CurrentPlayer.ID,
+			Pos:      pos,
+			Cmd:      ttt.CmdMove,
+		})
+		a1 = <-ttts.Announce
+		a2 = <-ttts.Announce
+		mark := ttt.MarkX
+		if i%2 == 1 {
+			mark = ttt.MarkO
+		}
+		expected := ttt.MoveEvent{
+			Seq:  i + 1,
+			Pos:  pos,
+			Mark: mark,
+		}
+		assert.Equal(t, *a1.Move, expected)
+		assert.Equal(t, *a2.Move, expected)
+		assert.Equal(t, a1.toPlayerStatus().Seq, i+1)
+		if i+1 < ttt.SnapshotInterval {
+			assert.Nil(t, a1.GridSnap)
+		} else {
+			assert.Equal(t, *a1.GridSnap, *rd.Grid)
+		}
+	}
+	tttsTeardown()
+}
+
+func TestTTTSProcessResync(t *testing.T) {
+	player1 := &Player{
+		ID:   "player-1",
+		Name: "Adam",
+	}
+	player2 := &Player{
+		ID:   "player-2",
+		Name: "John",
+	}
+	rd := ttts.createNewRound(player1, player2)
+	<-ttts.Announce
+	<-ttts.Announce
+
+	ttts.ProcessResync(&ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: rd.NextPlayer.ID,
+		Cmd:      ttt.CmdResync,
+	})
+	assert.Equal(t, len(ttts.Announce), 1)
+	a := <-ttts.Announce
+	assert.Equal(t, a.ToPlayer, *rd.NextPlayer)
+	assert.Equal(t, a.Status, ttt.StatusWaitTurn)
+	assert.NotNil(t, a.GridSnap)
+
+	ttts.ProcessResync(&ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: "stranger",
+		Cmd:      ttt.CmdResync,
+	})
+	assert.Equal(t, len(ttts.Announce), 0)
+	tttsTeardown()
+}
+
+func TestTTTSProcessHint(t *testing.T) {
+	player1 := &Player{ID: "player-1", Name: "Adam"}
+	player2 := &Player{ID: "player-2", Name: "John"}
+	hint := func(rd Round, p *Player) string {
+		return ttts.ProcessHint(&ttt.PlayerAction{
+			RoundID:  rd.ID,
+			PlayerID: p.ID,
+			Cmd:      ttt.CmdHint,
+		})
+	}
+
+	// no hints in rated rounds unless the server allows them
+	rd := ttts.createNewRound(player1, player2)
+	<-ttts.Announce
+	<-ttts.Announce
+	assert.Equal(t, rd.Hints, ttt.HintsOff)
+	assert.Equal(t, hint(rd, rd.CurrentPlayer), ttt.ReasonNoHints)
+
+	ttts.RatedHints = ttt.HintsServer
+	defer func() { ttts.RatedHints = ttt.HintsOff }()
+	rd = ttts.createNewRound(player1, player2)
+	a := <-ttts.Announce
+	<-ttts.Announce
+	assert.Equal(t, a.toPlayerStatus().Hints, ttt.HintsServer)
+	assert.Equal(t, hint(rd, rd.NextPlayer), ttt.ReasonNotYourTurn)
+	assert.Equal(t, hint(rd, &Player{ID: "stranger"}), ttt.ReasonUnknownRound)
+	rd.Grid.Set(ttt.Position{X: 0, Y: 0}, ttt.MarkO)
+	rd.Grid.Set(ttt.Position{X: 1, Y: 0}, ttt.MarkO)
+	assert.Equal(t, hint(rd, rd.CurrentPlayer), "")
+	a = <-ttts.Announce
+	assert.Equal(t, a.ToPlayer, *rd.CurrentPlayer)
+	assert.Equal(t, *a.Hint, ttt.Position{X: 2, Y: 0})
+	assert.Equal(t, a.Notice, "Hint: c1")
+	rd = (*ttts.Groups)[rd.ID]
+	assert.Equal(t, rd.HintsX, 1)
+	assert.Equal(t, rd.HintsO, 0)
+
+	// rounds against bots always allow local hints
+	bot := &Player{ID: "bot-1", Name: "Bot", AI: true}
+	rd = ttts.createNewRound(player1, bot)
+	assert.Equal(t, rd.Hints, ttt.HintsLocal)
+	<-ttts.Announce
+	<-ttts.Announce
+	// the client works local hints out, so they are only counted
+	assert.Equal(t, hint(rd, rd.CurrentPlayer), "")
+	assert.Equal(t, len(ttts.Announce), 0)
+	rd = (*ttts.Groups)[rd.ID]
+	assert.Equal(t, rd.HintsX, 1)
+	tttsTeardown()
+}
+
+func TestTTTSProcessActionAck(t *testing.T) {
+	player1 := &Player{
+		ID:   "player-1",
+		Name: "Adam",
+	}
+	player2 := &Player{
+		ID:   "player-2",
+		Name: "John",
+	}
+	rd := ttts.createNewRound(player1, player2)
+	<-ttts.Announce
+	<-ttts.Announce
+
+	m := &ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: rd.CurrentPlayer.ID,
+		Pos:      ttt.Position{X: 1, Y: 1},
+		Cmd:      ttt.CmdMove,
+		Seq:      1,
+	}
+	assert.True(t, ttts.ProcessAction(rd.CurrentPlayer, m))
+	assert.Equal(t, len(ttts.Announce), 3)
+	<-ttts.Announce
+	<-ttts.Announce
+	a := <-ttts.Announce
+	assert.Equal(t, *a.Ack, ttt.ActionAck{Seq: 1, OK: true})
+
+	// a retransmitted move is only acknowledged again
+	assert.True(t, ttts.ProcessAction(rd.CurrentPlayer, m))
+	assert.Equal(t, len(ttts.Announce), 1)
+	a = <-ttts.Announce
+	assert.Equal(t, *a.Ack, ttt.ActionAck{Seq: 1, OK: true})
+	assert.Equal(t, (*ttts.Groups)[rd.ID].Seq, 1)
+	tttsTeardown()
+}
+
+func TestTTTSProcessActionNack(t *testing.T) {
+	player1 := &Player{
+		ID:   "player-1",
+		Name: "Adam",
+	}
+	player2 := &Player{
+		ID:   "player-2",
+		Name: "John",
+	}
+	rd := ttts.createNewRound(player1, player2)
+	<-ttts.Announce
+	<-ttts.Announce
+
+	m := &ttt.PlayerAction{
+		RoundID:  rd.ID,
+		PlayerID: rd.NextPlayer.ID,
+		Pos:      ttt.Position{X: 1, Y: 1},
+		Cmd:      ttt.CmdMove,
+		Seq:      1,
+	}
+	assert.True(t, ttts.ProcessAction(rd.NextPlayer, m))
+	assert.Equal(t, len(ttts.Announce), 1)
+	a := <-ttts.Announce
+	assert.Equal(t, *a.Ack, ttt.ActionAck{
+		Seq:    1,
+		OK:     false,
+		Reason: ttt.ReasonNotYourTurn,
+	})
+
+	rd.Grid.Set(ttt.Position{X: 1, Y: 1}, ttt.MarkO)
+	m.PlayerID = rd.CurrentPlayer.ID
+	m.Seq = 1
+	assert.True(t, ttts.ProcessAction(rd.CurrentPlayer, m))
+	a = <-ttts.Announce
+	assert.Equal(t, a.Ack.Reason, ttt.ReasonCellTaken)
+
+	m.RoundID = "no-such-round"
+	m.Seq = 2
+	assert.True(t, ttts.ProcessAction(rd.CurrentPlayer, m))
+	a = <-ttts.Announce
+	assert.Equal(t, a.Ack.Reason, ttt.ReasonUnknownRound)
+	tttsTeardown()
+}
+
+func TestTTTSShutdown(t *testing.T) {
+	stop := make(chan bool)
+	go func() {
+		for {
+			select {
+			case a := <-ttts.Announce:
+				ttts.ProcessAnnouncement(a)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	conns := []net.Conn{}
+	lines := []chan string{}
+	handlers := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		server, client := net.Pipe()
+		l := make(chan string, 100)
+		go func() {
+			scanner := bufio.NewScanner(client)
+			for scanner.Scan() {
+				l <- scanner.Text()
+			}
+			close(l)
+		}()
+		handlers.Add(1)
+		go func() {
+			LineHandler(server)
+			handlers.Done()
+		}()
+		conns = append(conns, client)
+		lines = append(lines, l)
+		assert.True(t, waitLine(l, "status: "+ttt.StatusConnected))
+	}
+	conns[0].Write([]byte("join Adam\n"))
+	assert.True(t, waitLine(lines[0], "ok"))
+	conns[1].Write([]byte("join Eve\n"))
+	assert.True(t, waitLine(lines[1], "ok"))
+	assert.Equal(t, ttts.roundCount(), 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		ttts.Shutdown(ctx)
+		done <- true
+	}()
+	for _, l := range lines {
+		assert.True(t, waitLine(l, "notice: "+ttt.NoticeShutdown))
+	}
+	// no more joins, while the round goes on
+	conns[2].Write([]byte("join Bob\n"))
+	assert.True(t, waitLine(lines[2], "error: "+ttt.ReasonShuttingDown))
+	assert.Equal(t, ttts.roundCount(), 1)
+
+	// the round is aborted once the time is up
+	cancel()
+	<-done
+	assert.True(t, waitLine(lines[0], "status: "+ttt.StatusAborted))
+	assert.True(t, waitLine(lines[1], "status: "+ttt.StatusAborted))
+	for _, l := range lines {
+		assert.True(t, waitLine(l, "status: "+ttt.StatusShutdown))
+	}
+	handlers.Wait()
+	assert.Equal(t, ttts.roundCount(), 0)
+	assert.Equal(t, ttts.playerCount(), 0)
+	stop <- true
+	tttsTeardown()
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+const (
+	// How often the state of the server is saved
+	StateSaveInterval = 10 * time.Second
+	// How long a restored session waits for its player to come back
+	ResumeTimeout = 2 * time.Minute
+)
+
+// A player as saved in a snapshot. Token is empty for bots and players
+// of other nodes.
+type SessionSnapshot struct {
+	Token   string `json:"token,omitempty"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Score   int    `json:"score"`
+	RoundID string `json:"round_id,omitempty"`
+	AI      bool   `json:"ai,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+type RoundSnapshot struct {
+	ID      string   `json:"id"`
+	X       string   `json:"x"`
+	O       string   `json:"o"`
+	Current string   `json:"current"`
+	Grid    ttt.Grid `json:"grid"`
+	Seq     int      `json:"seq"`
+	Hints   string   `json:"hints,omitempty"`
+	HintsX  int      `json:"hints_x,omitempty"`
+	HintsO  int      `json:"hints_o,omitempty"`
+}
+
+// Everything needed to pick the games up again after a restart
+type ServerSnapshot struct {
+	Saved    time.Time         `json:"saved"`
+	Sessions []SessionSnapshot `json:"sessions"`
+	Rounds   []RoundSnapshot   `json:"rounds"`
+	// IDs of the waiting players, in order
+	Queue []string `json:"queue"`
+}
+
+// Take a snapshot of the sessions, rounds and waiting queue
+func (ttts *TTTServer) Snapshot() *ServerSnapshot {
+	snap := &ServerSnapshot{
+		Saved:    time.Now(),
+		Sessions: []SessionSnapshot{},
+		Rounds:   []RoundSnapshot{},
+		Queue:    []string{},
+	}
+	seen := make(map[string]bool)
+	add := func(p *Player) {
+		if p == nil || seen[p.ID] {
+			return
+		}
+		seen[p.ID] = true
+		snap.Sessions = append(snap.Sessions, SessionSnapshot{
+			Token:   p.Token,
+			ID:      p.ID,
+			Name:    p.Name,
+			Score:   p.Score,
+			RoundID: p.RoundID,
+			AI:      p.AI,
+			Level:   p.Level,
+			Node:    p.Node,
+		})
+	}
+	ttts.lock.Lock()
+	for _, p := range *ttts.Players {
+		add(p)
+	}
+	for _, p := range ttts.detached {
+		add(p)
+	}
+	for _, rd := range *ttts.Groups {
+		add(rd.XPlayer)
+		add(rd.OPlayer)
+		snap.Rounds = append(snap.Rounds, RoundSnapshot{
+			ID:      rd.ID,
+			X:       rd.XPlayer.ID,
+			O:       rd.OPlayer.ID,
+			Current: rd.CurrentPlayer.ID,
+			Grid:    *rd.Grid,
+			Seq:     rd.Seq,
+			Hints:   rd.Hints,
+			HintsX:  rd.HintsX,
+			HintsO:  rd.HintsO,
+		})
+	}
+	ttts.lock.Unlock()
+	for _, p := range ttts.BenchPlayers.Players() {
+		add(p)
+		snap.Queue = append(snap.Queue, p.ID)
+	}
+	return snap
+}
+
+// Bring back the rounds and waiting queue of a snapshot. Players are
+// detached until they resume their session, bots play on right away.
+func (ttts *TTTServer) Restore(snap *ServerSnapshot) {
+	players := make(map[string]*Player)
+	detached := make(map[string]*Player)
+	for _, s := range snap.Sessions {
+		p := &Player{
+			ID:      s.ID,
+			Name:    s.Name,
+			Score:   s.Score,
+			RoundID: s.RoundID,
+			Token:   s.Token,
+			AI:      s.AI,
+			Level:   s.Level,
+			Node:    s.Node,
+		}
+		players[p.ID] = p
+		if p.Token != "" {
+			detached[p.Token] = p
+		}
+	}
+	ttts.lock.Lock()
+	ttts.detached = detached
+	ttts.lock.Unlock()
+	for _, rs := range snap.Rounds {
+		x := players[rs.X]
+		o := players[rs.O]
+		if x == nil || o == nil {
+			glog.Warningln("skip round", rs.ID, "with unknown players")
+			continue
+		}
+		grid := rs.Grid
+		rd := Round{
+			ID:            rs.ID,
+			CurrentPlayer: x,
+			NextPlayer:    o,
+			Grid:          &grid,
+			Seq:           rs.Seq,
+			XPlayer:       x,
+			OPlayer:       o,
+			Hints:         rs.Hints,
+			HintsX:        rs.HintsX,
+			HintsO:        rs.HintsO,
+		}
+		if rs.Current == o.ID {
+			rd.switchTurn()
+		}
+		ttts.saveRound(rd)
+		ttts.Backend.ClaimRound(rd.ID)
+		for _, p := range []*Player{x, o} {
+			if p.AI {
+				am.NewAIPlayer(p.ID, p.Level)
+				ttts.ProcessResync(&ttt.PlayerAction{
+					RoundID:  rd.ID,
+					PlayerID: p.ID,
+				})
+			}
+		}
+	}
+	for _, id := range snap.Queue {
+		if p := players[id]; p != nil {
+			ttts.Backend.Enqueue(p)
+		}
+	}
+	glog.Infoln("restored", len(snap.Rounds), "rounds and",
+		len(detached), "sessions saved at", snap.Saved)
+	time.AfterFunc(ResumeTimeout, ttts.expireSessions)
+}
+
+// Give up on the restored players who did not come back
+func (ttts *TTTServer) expireSessions() {
+	ttts.lock.Lock()
+	expired := ttts.detached
+	ttts.detached = make(map[string]*Player)
+	ttts.lock.Unlock()
+	for _, p := range expired {
+		glog.Infoln("session of", p.repr(), "expired")
+		ttts.ProcessQuit(p)
+	}
+}
+
+// Hand the session of a player from before a restart over to a new
+// connection
+func (ttts *TTTServer) ProcessResume(p *Player, token string) string {
+	ttts.lock.Lock()
+	sp := ttts.detached[token]
+	if sp != nil {
+		delete(ttts.detached, token)
+		delete(*ttts.Players, p.ID)
+	}
+	ttts.lock.Unlock()
+	if sp == nil {
+		return ttt.ReasonUnknownSession
+	}
+	// the latency is kept again under the ID of the session
+	latencies.Delete(p.ID)
+	p.ID = sp.ID
+	p.Name = sp.Name
+	p.Score = sp.Score
+	p.RoundID = sp.RoundID
+	p.Token = sp.Token
+	ttts.addPlayer(p)
+	glog.Infoln("player", p.repr(), "resumed")
+
+	ttts.lock.Lock()
+	rd := (*ttts.Groups)[p.RoundID]
+	var a *Announcement
+	if rd.ID != "" {
+		rd.replacePlayer(sp, p)
+		(*ttts.Groups)[rd.ID] = rd
+		a = rd.stateFor(p)
+	}
+	ttts.lock.Unlock()
+	if a == nil {
+		p.RoundID = ""
+		status := ttt.StatusConnected
+		if ttts.BenchPlayers.Replace(sp, p) {
+			status = ttt.StatusWait
+		}
+		ttts.Announce <- &Announcement{
+			ToPlayer: *p,
+			Status:   status,
+			Token:    p.Token,
+		}
+		return ""
+	}
+	a.Token = p.Token
+	ttts.Announce <- a
+	return ""
+}
+
+// Save a snapshot now. A nil error is returned when snapshots are off.
+func (ttts *TTTServer) saveSnapshot() error {
+	if ttts.SnapshotPath == "" {
+		return nil
+	}
+	ttts.snapshotLock.Lock()
+	defer ttts.snapshotLock.Unlock()
+	return WriteSnapshot(ttts.SnapshotPath, ttts.Snapshot())
+}
+
+// Save a snapshot unless shutting down, when the last one is taken by
+// Shutdown
+func (ttts *TTTServer) autoSnapshot() {
+	ttts.snapshotLock.Lock()
+	defer ttts.snapshotLock.Unlock()
+	if ttts.isClosing() {
+		return
+	}
+	if err := WriteSnapshot(ttts.SnapshotPath, ttts.Snapshot()); err != nil {
+		glog.Warningln("can not save snapshot", err)
+	}
+}
+
+func WriteSnapshot(path string, snap *ServerSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read a snapshot, nil if there is none
+func ReadSnapshot(path string) (*ServerSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	snap := &ServerSnapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+// A round between a player and a bot, and a player waiting
+func snapshotSetup() (*Player, *Player, *Player) {
+	human := &Player{ID: "p1", Name: "Adam", Score: 3, Token: "t1"}
+	bot := &Player{ID: "p2", Name: "Pikachu", Score: 7, AI: true}
+	waiting := &Player{ID: "p3", Name: "Eve", Token: "t3"}
+	(*ttts.Players)[human.ID] = human
+	(*ttts.Players)[waiting.ID] = waiting
+	ttts.Backend.Enqueue(waiting)
+	rd := ttts.createNewRound(human, bot)
+	g, _ := ttt.ParseGame("3/1X1/3 O")
+	*rd.Grid = g.Grd
+	rd.Seq = 1
+	rd.switchTurn()
+	(*ttts.Groups)[rd.ID] = rd
+	<-ttts.Announce
+	<-ttts.Announce
+	return human, bot, waiting
+}
+
+func TestTTTSSnapshotRestore(t *testing.T) {
+	human, bot, waiting := snapshotSetup()
+	rd := (*ttts.Groups)[human.RoundID]
+	data, err := json.Marshal(ttts.Snapshot())
+	assert.Nil(t, err)
+	tttsTeardown()
+
+	snap := &ServerSnapshot{}
+	assert.Nil(t, json.Unmarshal(data, snap))
+	assert.Equal(t, len(snap.Sessions), 3)
+	assert.Equal(t, snap.Queue, []string{waiting.ID})
+	ttts.Restore(snap)
+
+	restored := (*ttts.Groups)[rd.ID]
+	assert.Equal(t, *restored.Grid, *rd.Grid)
+	assert.Equal(t, restored.Seq, 1)
+	assert.Equal(t, restored.CurrentPlayer.ID, rd.CurrentPlayer.ID)
+	assert.Equal(t, restored.XPlayer.ID, rd.XPlayer.ID)
+	assert.Equal(t, restored.getPlayer(human.ID).Score, 3)
+	assert.Equal(t, len(ttts.detached), 2)
+	assert.Equal(t, ttts.BenchPlayers.Players()[0].ID, waiting.ID)
+
+	// the bot is back and told where the round is
+	assert.NotNil(t, (*am.AIPlayers)[bot.ID])
+	a := <-ttts.Announce
+	assert.Equal(t, a.ToPlayer.ID, bot.ID)
+	assert.Equal(t, *a.GridSnap, *rd.Grid)
+	tttsTeardown()
+}
+
+func TestTTTSProcessResume(t *testing.T) {
+	human, _, waiting := snapshotSetup()
+	snap := ttts.Snapshot()
+	tttsTeardown()
+	ttts.Restore(snap)
+	<-ttts.Announce
+
+	p := &Player{ID: "new"}
+	(*ttts.Players)[p.ID] = p
+	latencies.Set(p.ID, new(expvar.Int))
+	assert.Equal(t, ttts.ProcessResume(p, "nope"), ttt.ReasonUnknownSession)
+	assert.NotNil(t, latencies.Get("new"))
+	assert.Equal(t, ttts.ProcessResume(p, "t1"), "")
+	assert.Nil(t, latencies.Get("new"))
+	assert.Equal(t, p.ID, human.ID)
+	assert.Equal(t, p.Name, "Adam")
+	assert.Nil(t, (*ttts.Players)["new"])
+	assert.Equal(t, (*ttts.Players)[human.ID], p)
+	rd := (*ttts.Groups)[p.RoundID]
+	assert.Equal(t, rd.getPlayer(human.ID), p)
+	a := <-ttts.Announce
+	assert.Equal(t, a.Token, "t1")
+	assert.Equal(t, a.Rd.ID, rd.ID)
+	assert.NotNil(t, a.GridSnap)
+	// a session is resumed once
+	assert.Equal(t, ttts.ProcessResume(p, "t1"), ttt.ReasonUnknownSession)
+
+	// waiting players keep their place in the queue
+	p = &Player{ID: "other"}
+	assert.Equal(t, ttts.ProcessResume(p, "t3"), "")
+	assert.Equal(t, ttts.BenchPlayers.Players(), []*Player{p})
+	assert.Equal(t, p.ID, waiting.ID)
+	a = <-ttts.Announce
+	assert.Equal(t, a.Status, ttt.StatusWait)
+	tttsTeardown()
+}
+
+func TestTTTSexpireSessions(t *testing.T) {
+	_, _, _ = snapshotSetup()
+	snap := ttts.Snapshot()
+	tttsTeardown()
+	ttts.Restore(snap)
+	<-ttts.Announce
+
+	ttts.expireSessions()
+	assert.Equal(t, len(ttts.detached), 0)
+	assert.Equal(t, len(*ttts.Groups), 0)
+	assert.Equal(t, ttts.BenchPlayers.Len(), 0)
+	// the bot is told
+	a := <-ttts.Announce
+	assert.Equal(t, a.Status, ttt.StatusOtherLeft)
+	tttsTeardown()
+}
+
+func TestWriteReadSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ttt")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	snap, err := ReadSnapshot(path)
+	assert.Nil(t, snap)
+	assert.Nil(t, err)
+
+	_, _, _ = snapshotSetup()
+	assert.Nil(t, WriteSnapshot(path, ttts.Snapshot()))
+	snap, err = ReadSnapshot(path)
+	assert.Nil(t, err)
+	assert.Equal(t, len(snap.Rounds), 1)
+	assert.Equal(t, len(snap.Sessions), 3)
+	tttsTeardown()
+}
@@ -0,0 +1,296 @@
+// Browser client for the tic-tac-toe websocket protocol. It mirrors
+// ttt-client: the same commands, key bindings and statuses.
+(function () {
+  "use strict";
+
+  var MarkEmpty = 0;
+
+  var client = {
+    conn: null,
+    name: "",
+    id: "",
+    score: 0,
+    mark: MarkEmpty,
+    vsName: "",
+    vsScore: 0,
+    vsMark: MarkEmpty,
+    roundID: "",
+    status: "",
+    notice: "",
+    seq: 0,
+    grid: emptyGrid(),
+    cursor: {x: (TTT.Size - 1) / 2, y: (TTT.Size - 1) / 2},
+    actionSeq: 0,
+    pending: null,
+    // session to resume after losing the connection, kept across reloads
+    token: sessionStorage.getItem("ttt-token") || "",
+    reconnectWait: TTT.ReconnectWait,
+    level: TTT.Levels[TTT.Levels.length - 1]
+  };
+
+  function emptyGrid() {
+    var grid = [];
+    for (var x = 0; x < TTT.Size; x++) {
+      grid.push([]);
+      for (var y = 0; y < TTT.Size; y++) {
+        grid[x].push(MarkEmpty);
+      }
+    }
+    return grid;
+  }
+
+  function isOverStatus(s) {
+    return TTT.OverStatuses.indexOf(s) >= 0;
+  }
+
+  function send(cmd, pos, token, level) {
+    client.actionSeq++;
+    var m = {
+      round_id: client.roundID,
+      player_id: client.id,
+      player_name: client.name,
+      position: pos || {x: 0, y: 0},
+      cmd: cmd,
+      seq: client.actionSeq,
+      token: token,
+      level: level
+    };
+    client.conn.send(JSON.stringify(m));
+    return m;
+  }
+
+  function join(withAI) {
+    if (!isOverStatus(client.status)) {
+      return;
+    }
+    if (withAI) {
+      send(TTT.Cmds.JoinAI, null, null, client.level);
+    } else {
+      send(TTT.Cmds.Join);
+    }
+  }
+
+  function nextLevel() {
+    var i = TTT.Levels.indexOf(client.level);
+    client.level = TTT.Levels[(i + 1) % TTT.Levels.length];
+    client.notice = "AI level: " + client.level;
+    redraw();
+  }
+
+  // Download the record of the round from the archive
+  function exportGame() {
+    if (client.roundID !== "") {
+      window.location = "/api/archive/" + client.roundID + TTT.RecordExt;
+    }
+  }
+
+  function quit() {
+    send(TTT.Cmds.Quit);
+    client.token = "";
+    sessionStorage.removeItem("ttt-token");
+    client.conn.close();
+  }
+
+  function pin() {
+    var p = client.cursor;
+    if (client.roundID === "" || client.status !== TTT.Statuses.YourTurn ||
+        client.grid[p.x][p.y] !== MarkEmpty) {
+      return;
+    }
+    client.grid[p.x][p.y] = client.mark;
+    client.pending = send(TTT.Cmds.Move, {x: p.x, y: p.y});
+    redraw();
+  }
+
+  function moveCursor(dx, dy) {
+    var x = client.cursor.x + dx;
+    var y = client.cursor.y + dy;
+    if (x >= 0 && x < TTT.Size && y >= 0 && y < TTT.Size) {
+      client.cursor = {x: x, y: y};
+      redraw();
+    }
+  }
+
+  // Settle the pending move, taking it back if it was rejected
+  function handleAck(ack) {
+    var m = client.pending;
+    if (m === null || m.seq !== ack.seq) {
+      return;
+    }
+    if (!ack.ok && client.grid[m.position.x][m.position.y] === client.mark) {
+      client.grid[m.position.x][m.position.y] = MarkEmpty;
+    }
+    client.pending = null;
+  }
+
+  // Apply a snapshot or a move event, asking for a snapshot when
+  // some moves were missed
+  function syncGrid(s) {
+    var seq = s.seq || 0;
+    if (s.grid_snap) {
+      client.grid = s.grid_snap;
+      client.seq = seq;
+    } else if (!s.round_id) {
+      client.grid = emptyGrid();
+      client.seq = 0;
+    } else if (s.move && s.move.seq > client.seq + 1) {
+      send(TTT.Cmds.Resync);
+    } else if (s.move && s.move.seq === client.seq + 1) {
+      client.grid[s.move.position.x][s.move.position.y] = s.move.mark;
+      client.seq = s.move.seq;
+    }
+  }
+
+  function update(s) {
+    if (s.ack) {
+      handleAck(s.ack);
+    }
+    if (s.notice) {
+      client.notice = s.notice;
+    }
+    if (s.token) {
+      client.token = s.token;
+      sessionStorage.setItem("ttt-token", s.token);
+    }
+    if (!s.status) {
+      redraw();
+      return;
+    }
+    if (s.round_id && client.roundID !== s.round_id &&
+        !isOverStatus(client.status)) {
+      return;
+    }
+    client.roundID = s.round_id || "";
+    client.id = s.player_id || "";
+    client.score = s.player_score || 0;
+    client.mark = s.mark || MarkEmpty;
+    client.vsName = s.vs_name || "";
+    client.vsScore = s.score || 0;
+    client.vsMark = s.vs_mark || MarkEmpty;
+    client.status = s.status;
+    syncGrid(s);
+    redraw();
+  }
+
+  function markToRune(m) {
+    if (m !== MarkEmpty && m === client.mark) {
+      return TTT.MyRune;
+    } else if (m !== MarkEmpty && m === client.vsMark) {
+      return TTT.OtherRune;
+    }
+    return "";
+  }
+
+  function userScores() {
+    var s = client.name + ": " + client.score;
+    if (client.vsName) {
+      s += " VS " + client.vsName + ": " + client.vsScore;
+    }
+    return s;
+  }
+
+  function redraw() {
+    var table = document.getElementById("grid");
+    table.innerHTML = "";
+    for (var y = 0; y < TTT.Size; y++) {
+      var row = table.insertRow();
+      for (var x = 0; x < TTT.Size; x++) {
+        var cell = row.insertCell();
+        cell.textContent = markToRune(client.grid[x][y]);
+        cell.dataset.x = x;
+        cell.dataset.y = y;
+        if (x === client.cursor.x && y === client.cursor.y) {
+          cell.className = "cursor";
+        }
+      }
+    }
+    document.getElementById("scores").textContent = userScores();
+    document.getElementById("status").textContent = client.status +
+      (client.notice ? " - " + client.notice : "");
+  }
+
+  function onKey(e) {
+    switch (e.key) {
+    case "Enter": case " ": case "i":
+      pin();
+      break;
+    case "Escape": case "q":
+      quit();
+      break;
+    case "ArrowLeft": case "h":
+      moveCursor(-1, 0);
+      break;
+    case "ArrowDown": case "j":
+      moveCursor(0, 1);
+      break;
+    case "ArrowUp": case "k":
+      moveCursor(0, -1);
+      break;
+    case "ArrowRight": case "l":
+      moveCursor(1, 0);
+      break;
+    case "F1":
+      join(true);
+      break;
+    case "F2":
+      join(false);
+      break;
+    case "d":
+      nextLevel();
+      break;
+    case "e":
+      exportGame();
+      break;
+    default:
+      return;
+    }
+    e.preventDefault();
+  }
+
+  // Connect, resuming the session if there is one. A lost connection
+  // is retried with a growing delay.
+  function connect() {
+    var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+    var token = client.token;
+    client.conn = new WebSocket(scheme + location.host + "/");
+    client.conn.onopen = function () {
+      client.reconnectWait = TTT.ReconnectWait;
+      if (token) {
+        send(TTT.Cmds.Resume, null, token);
+      }
+    };
+    client.conn.onmessage = function (e) {
+      update(JSON.parse(e.data));
+    };
+    client.conn.onclose = function () {
+      client.status = TTT.Statuses.LossConnection;
+      redraw();
+      if (client.token) {
+        setTimeout(connect, client.reconnectWait);
+        client.reconnectWait = Math.min(2 * client.reconnectWait,
+                                        TTT.MaxReconnectWait);
+      }
+    };
+  }
+
+  client.name = (window.prompt("Your name?", "") || "Unknown").substr(0, 8);
+  document.addEventListener("keydown", onKey);
+  document.getElementById("grid").addEventListener("click", function (e) {
+    if (e.target.dataset.x !== undefined) {
+      client.cursor = {
+        x: parseInt(e.target.dataset.x, 10),
+        y: parseInt(e.target.dataset.y, 10)
+      };
+      pin();
+    }
+  });
+  document.getElementById("join-ai").addEventListener("click", function () {
+    join(true);
+  });
+  document.getElementById("join").addEventListener("click", function () {
+    join(false);
+  });
+  document.getElementById("quit").addEventListener("click", quit);
+  connect();
+  redraw();
+})();
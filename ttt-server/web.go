@@ -0,0 +1,89 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+// Browser client, speaking the same websocket protocol as ttt-client
+//
+//go:embed static
+var staticFiles embed.FS
+
+var indexTemplate = template.Must(
+	template.ParseFS(staticFiles, "static/index.html"))
+
+var staticHandler = http.FileServer(http.FS(staticFiles))
+
+// Protocol constants handed to the browser client
+type indexPage struct {
+	Title        string
+	Help         string
+	Size         int
+	MyRune       string
+	OtherRune    string
+	Cmds         map[string]string
+	Statuses     map[string]string
+	OverStatuses []string
+	Levels       []string
+	RecordExt    string
+	// Delays in milliseconds before reconnecting
+	ReconnectWait    int64
+	MaxReconnectWait int64
+}
+
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// Serve websocket connections and the browser client on the same
+// address
+func RootHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case isWebsocketUpgrade(r):
+		WSHandler(w, r)
+	case r.URL.Path == "/":
+		IndexHandler(w, r)
+	case strings.HasPrefix(r.URL.Path, "/static/"):
+		staticHandler.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func IndexHandler(w http.ResponseWriter, r *http.Request) {
+	page := indexPage{
+		Title:     ttt.Title,
+		Help:      ttt.HelpMsg,
+		Size:      ttt.Size,
+		MyRune:    string(ttt.MyRune),
+		OtherRune: string(ttt.OtherRune),
+		Cmds: map[string]string{
+			"Join":   ttt.CmdJoin,
+			"JoinAI": ttt.CmdJoinAI,
+			"Move":   ttt.CmdMove,
+			"Quit":   ttt.CmdQuit,
+			"Resync": ttt.CmdResync,
+			"Resume": ttt.CmdResume,
+		},
+		Statuses: map[string]string{
+			"YourTurn":       ttt.StatusYourTurn,
+			"LossConnection": ttt.StatusLossConnection,
+		},
+		OverStatuses:     ttt.OverStatuses,
+		Levels:           ttt.Levels,
+		RecordExt:        ttt.RecordExt,
+		ReconnectWait:    int64(ttt.ReconnectWait / time.Millisecond),
+		MaxReconnectWait: int64(ttt.MaxReconnectWait / time.Millisecond),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, page); err != nil {
+		glog.Warningln("can not render the browser client", err)
+	}
+}
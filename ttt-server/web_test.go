@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/wujiang/tic-tac-toe"
+)
+
+func webGet(path string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	RootHandler(w, r)
+	return w
+}
+
+func TestRootHandlerIndex(t *testing.T) {
+	w := webGet("/")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	body := w.Body.String()
+	assert.Contains(t, body, "<title>"+ttt.Title+"</title>")
+	assert.Contains(t, body, `<script src="/static/ttt.js"></script>`)
+	assert.Contains(t, body, `"YourTurn":"`+ttt.StatusYourTurn+`"`)
+	assert.Contains(t, body, `"JoinAI":"`+ttt.CmdJoinAI+`"`)
+	assert.Contains(t, body, `"Resume":"`+ttt.CmdResume+`"`)
+	assert.Contains(t, body, `"`+ttt.LevelIntermediate+`"`)
+}
+
+func TestRootHandlerStatic(t *testing.T) {
+	w := webGet("/static/ttt.js")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"),
+		"text/javascript"))
+	assert.Contains(t, w.Body.String(), "new WebSocket(")
+
+	w = webGet("/static/ttt.css")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"),
+		"text/css"))
+
+	w = webGet("/static/missing.js")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	w = webGet("/missing")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func TestRootHandlerWebsocket(t *testing.T) {
+	done := make(chan bool)
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			RootHandler(w, r)
+			close(done)
+		}))
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{})
+	assert.Nil(t, err)
+	a := <-ttts.Announce
+	assert.Equal(t, a.Status, ttt.StatusConnected)
+	assert.NotNil(t, a.ToPlayer.WS)
+	// wait for the server to close the connection after quitting
+	ws.WriteJSON(ttt.PlayerAction{Cmd: ttt.CmdQuit})
+	_, _, err = ws.ReadMessage()
+	assert.NotNil(t, err)
+	ws.Close()
+	// let the handler finish quitting
+	<-done
+	tttsTeardown()
+}
@@ -25,12 +25,17 @@ const (
 	SpecialRune rune = ' '
 	MyRune      rune = 'X'
 	OtherRune   rune = 'O'
+	HintRune    rune = '*'
 
 	CmdQuit     string = "Quit"
 	CmdJoin     string = "Join"
 	CmdJoinAI   string = "Join AI"
 	CmdMove     string = "Move"
 	CmdNewRound string = "New round"
+	CmdResync   string = "Resync"
+	CmdResume   string = "Resume"
+	CmdChat     string = "Chat"
+	CmdHint     string = "Hint"
 
 	StatusInit           string = ""
 	StatusConnected      string = "Connected to server"
@@ -44,12 +49,66 @@ const (
 	StatusYourTurn       string = "Your turn"
 	StatusWaitTurn       string = "Other user's turn"
 	StatusLossConnection string = "Loss connection from server"
+	StatusAborted        string = "Round was aborted"
+	StatusShutdown       string = "Server is shutting down"
+
+	// Told to everyone when the server starts shutting down
+	NoticeShutdown string = "Server is shutting down, finish your round"
+
+	// Reasons for rejecting an action
+	ReasonUnknownCmd      string = "Unknown command"
+	ReasonUnknownRound    string = "No such round"
+	ReasonNotYourTurn     string = "Not your turn"
+	ReasonInvalidPosition string = "Invalid position"
+	ReasonCellTaken       string = "Cell is already taken"
+	ReasonShuttingDown    string = "Server is shutting down"
+	ReasonUnknownSession  string = "No such session"
+	ReasonEmptyText       string = "Nothing to say"
+	ReasonUnknownLevel    string = "No such AI level"
+	ReasonNoHints         string = "No hints in this round"
+
+	// Whether a round allows hints. With local hints clients may work
+	// them out themselves, with server hints only the server gives them.
+	// Either way the server counts the hints asked for.
+	HintsLocal  string = "local"
+	HintsServer string = "server"
+	HintsOff    string = "off"
+
+	// How well bots play. Beginners often move at random and
+	// intermediates only look a couple of moves ahead. Experts never
+	// lose, and are who you get when no level is given.
+	LevelBeginner     string = "beginner"
+	LevelIntermediate string = "intermediate"
+	LevelExpert       string = "expert"
 
 	Score = 1
 
+	// Longer chat messages are cut
+	ChatLengthLimit = 140
+
+	// A full grid snapshot is sent every SnapshotInterval moves
+	SnapshotInterval = 4
+
+	// Websocket heartbeat. A connection without any pong for PongWait
+	// is considered dead.
+	PingInterval = 10 * time.Second
+	PongWait     = 3 * PingInterval
+	WriteWait    = time.Second
+
+	// An action without acknowledgement for AckTimeout is sent again,
+	// up to MaxRetransmits times
+	AckTimeout     = 2 * time.Second
+	MaxRetransmits = 3
+
+	// A lost connection is retried after ReconnectWait, doubled on each
+	// failure up to MaxReconnectWait
+	ReconnectWait    = time.Second
+	MaxReconnectWait = 30 * time.Second
+
 	Title   = "Tic-tac-toe"
 	HelpMsg = `
 - 1-PERSON GAME: f1
+- AI LEVEL: d
 - 2-PERSON GAME: f2
 - LEFT: h, ctrl-b, arrow-left
 - DOWN: j, ctrl-n, arrow-down
@@ -57,6 +116,17 @@ const (
 - RIGHT: l, ctrl-f, arrow-right
 - EXIT: q, esc
 - ENTER: i, enter, space
+- EXPORT GAME: e
+- HINT: ?
+`
+	ReplayHelpMsg = `
+- BACK: h, ctrl-b, arrow-left
+- FORWARD: l, ctrl-f, arrow-right
+- START: k, ctrl-p, arrow-up
+- END: j, ctrl-n, arrow-down
+- PLAY/PAUSE: enter, space
+- FASTER/SLOWER: +, -
+- EXIT: q, esc
 `
 )
 
@@ -68,6 +138,8 @@ var OverStatuses = []string{
 	StatusTie,
 	StatusOtherLeft,
 	StatusWait,
+	StatusAborted,
+	StatusShutdown,
 }
 
 var AIOverStatuses = []string{
@@ -75,6 +147,14 @@ var AIOverStatuses = []string{
 	StatusLoss,
 	StatusTie,
 	StatusOtherLeft,
+	StatusAborted,
+	StatusShutdown,
+}
+
+var Levels = []string{
+	LevelBeginner,
+	LevelIntermediate,
+	LevelExpert,
 }
 
 var Corners = []Position{
@@ -106,6 +186,10 @@ func IsAIOverStatus(s string) bool {
 	return itemInSlice(s, AIOverStatuses)
 }
 
+func IsValidLevel(l string) bool {
+	return l == "" || itemInSlice(l, Levels)
+}
+
 type Position struct {
 	X int `json:"x"`
 	Y int `json:"y"`
@@ -124,14 +208,45 @@ func IsValidPosition(p Position) bool {
 	return p.X >= 0 && p.X < Size && p.Y >= 0 && p.Y < Size
 }
 
-type Grid [Size][Size]string
+// Mark in a cell of the grid. Players are seated as a mark for each
+// round, so grids never refer to who is playing.
+type Mark uint8
+
+const (
+	MarkEmpty Mark = iota
+	MarkX
+	MarkO
+)
+
+var markNames = []string{"", "X", "O"}
+
+func (m Mark) String() string {
+	if int(m) < len(markNames) {
+		return markNames[m]
+	}
+	return "?"
+}
+
+// The mark of the opponent
+func (m Mark) Other() Mark {
+	switch m {
+	case MarkX:
+		return MarkO
+	case MarkO:
+		return MarkX
+	default:
+		return MarkEmpty
+	}
+}
+
+type Grid [Size][Size]Mark
 
-func (g *Grid) Get(p Position) string {
+func (g *Grid) Get(p Position) Mark {
 	return g[p.X][p.Y]
 }
 
-func (g *Grid) Set(p Position, s string) {
-	g[p.X][p.Y] = s
+func (g *Grid) Set(p Position, m Mark) {
+	g[p.X][p.Y] = m
 }
 
 // Cells in its horizontal row
@@ -195,8 +310,8 @@ func (g *Grid) RDRowNeighbors(p Position) []Position {
 }
 
 // Check if the give position has same marks in a row
-func (g *Grid) HasSameMarksInRows(p Position, s string) bool {
-	g.Set(p, s)
+func (g *Grid) HasSameMarksInRows(p Position, m Mark) bool {
+	g.Set(p, m)
 	ns := [][]Position{
 		g.HRowNeighbors(p),
 		g.VRowNeighbors(p),
@@ -225,8 +340,8 @@ func (g *Grid) HasSameMarksInRows(p Position, s string) bool {
 
 func (g *Grid) IsFull() bool {
 	for _, l := range g {
-		for _, s := range l {
-			if s == "" {
+		for _, m := range l {
+			if m == MarkEmpty {
 				return false
 			}
 		}
@@ -236,8 +351,8 @@ func (g *Grid) IsFull() bool {
 
 func (g *Grid) IsEmpty() bool {
 	for _, l := range g {
-		for _, s := range l {
-			if s != "" {
+		for _, m := range l {
+			if m != MarkEmpty {
 				return false
 			}
 		}
@@ -245,6 +360,28 @@ func (g *Grid) IsEmpty() bool {
 	return true
 }
 
+// Bring the grid up to date with a status. seq is the sequence number
+// of the last move reflected in the grid. Return the new sequence
+// number and false if some moves were missed, in which case a full
+// snapshot should be requested with CmdResync.
+func (g *Grid) Sync(seq int, s *PlayerStatus) (int, bool) {
+	switch {
+	case s.GridSnap != nil:
+		*g = *s.GridSnap
+		return s.Seq, true
+	case s.RoundID == "":
+		*g = Grid{}
+		return 0, true
+	case s.Move == nil || s.Move.Seq <= seq:
+		// nothing new or a retransmitted move
+		return seq, true
+	case s.Move.Seq > seq+1:
+		return seq, false
+	}
+	g.Set(s.Move.Pos, s.Move.Mark)
+	return s.Move.Seq, true
+}
+
 func (g *Grid) GetRandomCorner() Position {
 	return Corners[RandInt(4)]
 }
@@ -252,8 +389,8 @@ func (g *Grid) GetRandomCorner() Position {
 func (g *Grid) GetAvailableCells() []Position {
 	var pos []Position
 	for x, l := range g {
-		for y, s := range l {
-			if s == "" {
+		for y, m := range l {
+			if m == MarkEmpty {
 				pos = append(pos, Position{x, y})
 			}
 		}
@@ -295,13 +432,13 @@ func (gs GameResults) Max() GameResult {
 }
 
 type Game struct {
-	CurrentPlayer string
-	NextPlayer    string
+	CurrentPlayer Mark
+	NextPlayer    Mark
 	Grd           Grid
 }
 
 // Return score and whether or not the game is over
-func (g Game) Judge(player string, pos Position) (int, bool) {
+func (g Game) Judge(player Mark, pos Position) (int, bool) {
 	if g.Grd.HasSameMarksInRows(pos, player) {
 		return Score, true
 	} else if g.Grd.IsFull() {
@@ -318,7 +455,14 @@ func (g *Game) SwitchTurn() {
 }
 
 // Use minmax to get the best move for a player
-func (g Game) GetBestMove(player string) GameResult {
+func (g Game) GetBestMove(player Mark) GameResult {
+	return g.GetBestMoveWithin(player, -1)
+}
+
+// Like GetBestMove, looking no more than depth moves ahead. Positions
+// further away are scored as ties, so at depth 0 the first free cell
+// is taken. A negative depth has no limit.
+func (g Game) GetBestMoveWithin(player Mark, depth int) GameResult {
 	if g.Grd.IsEmpty() {
 		return GameResult{
 			Score: 0,
@@ -328,6 +472,12 @@ func (g Game) GetBestMove(player string) GameResult {
 	var gs GameResults
 
 	pos := g.Grd.GetAvailableCells()
+	if depth == 0 {
+		if len(pos) == 0 {
+			return GameResult{}
+		}
+		return GameResult{0, pos[0]}
+	}
 	for _, p := range pos {
 		ng := g
 		score, over := ng.Judge(ng.CurrentPlayer, p)
@@ -345,7 +495,7 @@ func (g Game) GetBestMove(player string) GameResult {
 		} else {
 			ng.Grd.Set(p, ng.CurrentPlayer)
 			(&ng).SwitchTurn()
-			rd := ng.GetBestMove(player)
+			rd := ng.GetBestMoveWithin(player, depth-1)
 			gs = append(gs, GameResult{rd.Score, p})
 		}
 
@@ -364,18 +514,53 @@ type PlayerAction struct {
 	PlayerName string   `json:"player_name,omitempty"`
 	Pos        Position `json:"position"`
 	Cmd        string   `json:"cmd"`
+	Seq        int      `json:"seq,omitempty"`
+	// Session to resume with CmdResume
+	Token string `json:"token,omitempty"`
+	// Message sent with CmdChat
+	Text string `json:"text,omitempty"`
+	// Level of the bot asked for with CmdJoinAI
+	Level string `json:"level,omitempty"`
+}
+
+// Acknowledgement of the action numbered Seq on a connection. Reason
+// tells why the action was rejected.
+type ActionAck struct {
+	Seq    int    `json:"seq"`
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// A single move in a round. Seq counts the moves made in the round,
+// starting from 1.
+type MoveEvent struct {
+	Seq  int      `json:"seq"`
+	Pos  Position `json:"position"`
+	Mark Mark     `json:"mark"`
 }
 
 type PlayerStatus struct {
-	RoundID     string `json:"round_id,omitempty"`
-	PlayerName  string `json:"player_name,omitempty"`
-	PlayerID    string `json:"player_id,omitempty"`
-	PlayerScore int    `json:"player_score,omitempty"`
-	VSID        string `json:"vs_id,omitempty"`
-	VSName      string `json:"vs_name,omitempty"`
-	VSScore     int    `json:"score,omitempty"`
-	Status      string `json:"status"`
-	GridSnap    *Grid  `json:"grid_snap"`
+	RoundID     string     `json:"round_id,omitempty"`
+	PlayerName  string     `json:"player_name,omitempty"`
+	PlayerID    string     `json:"player_id,omitempty"`
+	PlayerScore int        `json:"player_score,omitempty"`
+	Mark        Mark       `json:"mark,omitempty"`
+	VSID        string     `json:"vs_id,omitempty"`
+	VSName      string     `json:"vs_name,omitempty"`
+	VSScore     int        `json:"score,omitempty"`
+	VSMark      Mark       `json:"vs_mark,omitempty"`
+	Status      string     `json:"status"`
+	Seq         int        `json:"seq,omitempty"`
+	Move        *MoveEvent `json:"move,omitempty"`
+	GridSnap    *Grid      `json:"grid_snap,omitempty"`
+	Ack         *ActionAck `json:"ack,omitempty"`
+	// A message for the player which does not change the status
+	Notice string `json:"notice,omitempty"`
+	// Session of the player, to resume it after losing the connection
+	Token string `json:"token,omitempty"`
+	// Whether the round allows hints, and the hint asked for
+	Hints string    `json:"hints,omitempty"`
+	Hint  *Position `json:"hint,omitempty"`
 }
 
 func (s *PlayerStatus) Repr() string {
@@ -26,16 +26,28 @@ func TestIsValidPosition(t *testing.T) {
 	assert.False(t, IsValidPosition(Position{3, 0}))
 }
 
+func TestMarkString(t *testing.T) {
+	assert.Equal(t, MarkEmpty.String(), "")
+	assert.Equal(t, MarkX.String(), "X")
+	assert.Equal(t, MarkO.String(), "O")
+}
+
+func TestMarkOther(t *testing.T) {
+	assert.Equal(t, MarkX.Other(), MarkO)
+	assert.Equal(t, MarkO.Other(), MarkX)
+	assert.Equal(t, MarkEmpty.Other(), MarkEmpty)
+}
+
 func TestGridGet(t *testing.T) {
 	gd := Grid{}
-	assert.Equal(t, gd.Get(Position{0, 0}), "")
+	assert.Equal(t, gd.Get(Position{0, 0}), MarkEmpty)
 }
 
 func TestGridSet(t *testing.T) {
 	gd := Grid{}
 	pos := Position{0, 0}
-	gd.Set(pos, "X")
-	assert.Equal(t, gd.Get(pos), "X")
+	gd.Set(pos, MarkX)
+	assert.Equal(t, gd.Get(pos), MarkX)
 }
 
 func TestGridHRowNeighbors(t *testing.T) {
@@ -68,30 +80,30 @@ func TestGridRDRowNeighbors(t *testing.T) {
 
 func TestGridHasSameMarksInRows(t *testing.T) {
 	gd := Grid{}
-	assert.True(t, gd.HasSameMarksInRows(Position{1, 1}, ""))
-	assert.False(t, gd.HasSameMarksInRows(Position{1, 1}, "X"))
+	assert.True(t, gd.HasSameMarksInRows(Position{1, 1}, MarkEmpty))
+	assert.False(t, gd.HasSameMarksInRows(Position{1, 1}, MarkX))
 	gd = Grid{
-		{"X", "O", "X"},
-		{"", "", "O"},
-		{"O", "", "X"},
+		{MarkX, MarkO, MarkX},
+		{MarkEmpty, MarkEmpty, MarkO},
+		{MarkO, MarkEmpty, MarkX},
 	}
-	assert.True(t, gd.HasSameMarksInRows(Position{1, 1}, "X"))
-	assert.False(t, gd.HasSameMarksInRows(Position{1, 0}, "X"))
+	assert.True(t, gd.HasSameMarksInRows(Position{1, 1}, MarkX))
+	assert.False(t, gd.HasSameMarksInRows(Position{1, 0}, MarkX))
 }
 
 func TestGridIsFull(t *testing.T) {
 	gd := Grid{}
 	assert.False(t, gd.IsFull())
 	gd = Grid{
-		{"X", "O", "X"},
-		{"", "", "O"},
-		{"O", "", "X"},
+		{MarkX, MarkO, MarkX},
+		{MarkEmpty, MarkEmpty, MarkO},
+		{MarkO, MarkEmpty, MarkX},
 	}
 	assert.False(t, gd.IsFull())
 	gd = Grid{
-		{"X", "O", "X"},
-		{"X", "O", "O"},
-		{"O", "X", "X"},
+		{MarkX, MarkO, MarkX},
+		{MarkX, MarkO, MarkO},
+		{MarkO, MarkX, MarkX},
 	}
 	assert.True(t, gd.IsFull())
 }
@@ -100,23 +112,63 @@ func TestGridIsEmpty(t *testing.T) {
 	gd := Grid{}
 	assert.True(t, gd.IsEmpty())
 	gd = Grid{
-		{"X", "O", "X"},
-		{"", "", "O"},
-		{"O", "", "X"},
+		{MarkX, MarkO, MarkX},
+		{MarkEmpty, MarkEmpty, MarkO},
+		{MarkO, MarkEmpty, MarkX},
 	}
 	assert.False(t, gd.IsEmpty())
 }
 
 func TestGridGetAvailableCells(t *testing.T) {
 	gd := Grid{
-		{"X", "", "O"},
-		{"", "O", "X"},
-		{"X", "", "O"},
+		{MarkX, MarkEmpty, MarkO},
+		{MarkEmpty, MarkO, MarkX},
+		{MarkX, MarkEmpty, MarkO},
 	}
 	available := []Position{Position{0, 1}, Position{1, 0}, Position{2, 1}}
 	assert.Equal(t, gd.GetAvailableCells(), available)
 }
 
+func TestGridSyncSnapshot(t *testing.T) {
+	gd := Grid{}
+	snap := Grid{{MarkX, MarkO}}
+	seq, ok := gd.Sync(0, &PlayerStatus{RoundID: "r", Seq: 2, GridSnap: &snap})
+	assert.True(t, ok)
+	assert.Equal(t, seq, 2)
+	assert.Equal(t, gd, snap)
+
+	seq, ok = gd.Sync(seq, &PlayerStatus{})
+	assert.True(t, ok)
+	assert.Equal(t, seq, 0)
+	assert.True(t, gd.IsEmpty())
+}
+
+func TestGridSyncMove(t *testing.T) {
+	gd := Grid{}
+	ps := &PlayerStatus{
+		RoundID: "r",
+		Move:    &MoveEvent{1, Position{1, 1}, MarkX},
+	}
+	seq, ok := gd.Sync(0, ps)
+	assert.True(t, ok)
+	assert.Equal(t, seq, 1)
+	assert.Equal(t, gd.Get(Position{1, 1}), MarkX)
+
+	// duplicates are ignored
+	ps.Move = &MoveEvent{1, Position{0, 0}, MarkO}
+	seq, ok = gd.Sync(seq, ps)
+	assert.True(t, ok)
+	assert.Equal(t, seq, 1)
+	assert.Equal(t, gd.Get(Position{0, 0}), MarkEmpty)
+
+	// gaps ask for a resync
+	ps.Move = &MoveEvent{3, Position{0, 0}, MarkX}
+	seq, ok = gd.Sync(seq, ps)
+	assert.False(t, ok)
+	assert.Equal(t, seq, 1)
+	assert.Equal(t, gd.Get(Position{0, 0}), MarkEmpty)
+}
+
 func TestGameResultsMin(t *testing.T) {
 	gs := GameResults{}
 	assert.Equal(t, gs.Min(), GameResult{})
@@ -145,76 +197,76 @@ func TestGameResultsMax(t *testing.T) {
 
 func TestGameSwitchTurn(t *testing.T) {
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           Grid{},
 	}
 	g.SwitchTurn()
-	assert.Equal(t, g.CurrentPlayer, "O")
-	assert.Equal(t, g.NextPlayer, "X")
+	assert.Equal(t, g.CurrentPlayer, MarkO)
+	assert.Equal(t, g.NextPlayer, MarkX)
 }
 
 func TestGameJudgeNotOver(t *testing.T) {
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           Grid{},
 	}
-	score, over := g.Judge("X", Position{0, 0})
+	score, over := g.Judge(MarkX, Position{0, 0})
 	assert.Equal(t, score, 0)
 	assert.False(t, over)
 }
 
 func TestGameJudgeWin(t *testing.T) {
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
-		Grd:           Grid{{"X", "X"}},
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
+		Grd:           Grid{{MarkX, MarkX}},
 	}
-	score, over := g.Judge("X", Position{0, 2})
+	score, over := g.Judge(MarkX, Position{0, 2})
 	assert.Equal(t, score, Score)
 	assert.True(t, over)
 }
 
 func TestGameJudgeTie(t *testing.T) {
 	grid := Grid{
-		{"X", "X", "O"},
-		{"O", "O", "X"},
-		{"", "X", "O"},
+		{MarkX, MarkX, MarkO},
+		{MarkO, MarkO, MarkX},
+		{MarkEmpty, MarkX, MarkO},
 	}
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           grid,
 	}
-	score, over := g.Judge("X", Position{2, 0})
+	score, over := g.Judge(MarkX, Position{2, 0})
 	assert.Equal(t, score, 0)
 	assert.True(t, over)
 }
 
 func TestGameGetBestMove(t *testing.T) {
 	grid := Grid{
-		{"O", "X", "X"},
-		{"", "", "O"},
-		{"X", "", "O"},
+		{MarkO, MarkX, MarkX},
+		{MarkEmpty, MarkEmpty, MarkO},
+		{MarkX, MarkEmpty, MarkO},
 	}
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("X")
+	r := g.GetBestMove(MarkX)
 	assert.Equal(t, r, GameResult{Score, Position{1, 1}})
 }
 
 func TestGameGetBestMove2(t *testing.T) {
 	grid := Grid{}
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("X")
+	r := g.GetBestMove(MarkX)
 	assert.Equal(t, r.Score, 0)
 	assert.True(t, Corners[0] == r.Pos || Corners[1] == r.Pos ||
 		Corners[2] == r.Pos || Corners[3] == r.Pos)
@@ -222,144 +274,206 @@ func TestGameGetBestMove2(t *testing.T) {
 
 func TestGameGetBestMove3(t *testing.T) {
 	grid := Grid{
-		{"X", "", ""},
-		{"", "", ""},
-		{"", "", ""},
+		{MarkX, MarkEmpty, MarkEmpty},
+		{MarkEmpty, MarkEmpty, MarkEmpty},
+		{MarkEmpty, MarkEmpty, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "O",
-		NextPlayer:    "X",
+		CurrentPlayer: MarkO,
+		NextPlayer:    MarkX,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("O")
+	r := g.GetBestMove(MarkO)
 	assert.Equal(t, r, GameResult{0, Position{1, 1}})
 }
 
 func TestGameGetBestMove4(t *testing.T) {
 	grid := Grid{
-		{"X", "", ""},
-		{"", "O", ""},
-		{"", "", ""},
+		{MarkX, MarkEmpty, MarkEmpty},
+		{MarkEmpty, MarkO, MarkEmpty},
+		{MarkEmpty, MarkEmpty, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("X")
+	r := g.GetBestMove(MarkX)
 	assert.Equal(t, r, GameResult{0, Position{0, 1}})
 }
 
 func TestGameGetBestMove5(t *testing.T) {
 	grid := Grid{
-		{"X", "X", ""},
-		{"", "O", ""},
-		{"", "", ""},
+		{MarkX, MarkX, MarkEmpty},
+		{MarkEmpty, MarkO, MarkEmpty},
+		{MarkEmpty, MarkEmpty, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "O",
-		NextPlayer:    "X",
+		CurrentPlayer: MarkO,
+		NextPlayer:    MarkX,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("O")
+	r := g.GetBestMove(MarkO)
 	assert.Equal(t, r, GameResult{0, Position{0, 2}})
 }
 
 func TestGameGetBestMove6(t *testing.T) {
 	grid := Grid{
-		{"X", "X", "O"},
-		{"", "O", ""},
-		{"", "", ""},
+		{MarkX, MarkX, MarkO},
+		{MarkEmpty, MarkO, MarkEmpty},
+		{MarkEmpty, MarkEmpty, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("X")
+	r := g.GetBestMove(MarkX)
 	assert.Equal(t, r, GameResult{0, Position{2, 0}})
 }
 
 func TestGameGetBestMove7(t *testing.T) {
 	grid := Grid{
-		{"X", "X", "O"},
-		{"", "O", ""},
-		{"X", "", ""},
+		{MarkX, MarkX, MarkO},
+		{MarkEmpty, MarkO, MarkEmpty},
+		{MarkX, MarkEmpty, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "O",
-		NextPlayer:    "X",
+		CurrentPlayer: MarkO,
+		NextPlayer:    MarkX,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("O")
+	r := g.GetBestMove(MarkO)
 	assert.Equal(t, r, GameResult{0, Position{1, 0}})
 }
 
 func TestGameGetBestMove8(t *testing.T) {
 	grid := Grid{
-		{"X", "X", "O"},
-		{"O", "O", ""},
-		{"X", "", ""},
+		{MarkX, MarkX, MarkO},
+		{MarkO, MarkO, MarkEmpty},
+		{MarkX, MarkEmpty, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("X")
+	r := g.GetBestMove(MarkX)
 	assert.Equal(t, r, GameResult{0, Position{1, 2}})
 }
 
 func TestGameGetBestMove9(t *testing.T) {
 	grid := Grid{
-		{"X", "X", "O"},
-		{"O", "O", "X"},
-		{"X", "", ""},
+		{MarkX, MarkX, MarkO},
+		{MarkO, MarkO, MarkX},
+		{MarkX, MarkEmpty, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "O",
-		NextPlayer:    "X",
+		CurrentPlayer: MarkO,
+		NextPlayer:    MarkX,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("O")
+	r := g.GetBestMove(MarkO)
 	assert.Equal(t, r, GameResult{0, Position{2, 1}})
 }
 
 func TestGameGetBestMove10(t *testing.T) {
 	grid := Grid{
-		{"X", "X", "O"},
-		{"O", "O", "X"},
-		{"X", "O", ""},
+		{MarkX, MarkX, MarkO},
+		{MarkO, MarkO, MarkX},
+		{MarkX, MarkO, MarkEmpty},
 	}
 	g := Game{
-		CurrentPlayer: "X",
-		NextPlayer:    "O",
+		CurrentPlayer: MarkX,
+		NextPlayer:    MarkO,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("X")
+	r := g.GetBestMove(MarkX)
 	assert.Equal(t, r, GameResult{0, Position{2, 2}})
 }
 
 func TestGameGetBestMove11(t *testing.T) {
 	grid := Grid{
-		{"O", "X", "X"},
-		{"X", "", "O"},
-		{"X", "", "O"},
+		{MarkO, MarkX, MarkX},
+		{MarkX, MarkEmpty, MarkO},
+		{MarkX, MarkEmpty, MarkO},
 	}
 	g := Game{
-		CurrentPlayer: "O",
-		NextPlayer:    "X",
+		CurrentPlayer: MarkO,
+		NextPlayer:    MarkX,
 		Grd:           grid,
 	}
-	r := g.GetBestMove("O")
+	r := g.GetBestMove(MarkO)
 	assert.Equal(t, r, GameResult{Score, Position{1, 1}})
 }
 
+func TestGameGetBestMovePositions(t *testing.T) {
+	positions := []struct {
+		position string
+		expected GameResult
+	}{
+		// win rather than block
+		{"XX1/OO1/X2 O", GameResult{Score, Position{2, 1}}},
+		// block the only threat
+		{"X1O/1X1/3 O", GameResult{0, Position{2, 2}}},
+		// block with a fork
+		{"X1O/1O1/2X X", GameResult{Score, Position{0, 2}}},
+	}
+	for _, p := range positions {
+		g, err := ParseGame(p.position)
+		assert.Nil(t, err, p.position)
+		r := g.GetBestMove(g.CurrentPlayer)
+		assert.Equal(t, r, p.expected, p.position)
+	}
+}
+
+func TestGameGetBestMoveWithin(t *testing.T) {
+	// O has to block the fork at c1 two moves ahead
+	g, err := ParseGame("X2/1O1/2X O")
+	assert.Nil(t, err)
+	r := g.GetBestMoveWithin(MarkO, -1)
+	assert.Equal(t, r.Score, 0)
+	assert.True(t, r.Pos.X == 1 || r.Pos.Y == 1, r.Pos)
+
+	// the fork is beyond two moves, so any free cell will do and the
+	// first one is taken
+	r = g.GetBestMoveWithin(MarkO, 2)
+	assert.Equal(t, r, GameResult{0, Position{0, 1}})
+
+	// but a threat to block is
+	g, err = ParseGame("X1O/1X1/3 O")
+	assert.Nil(t, err)
+	r = g.GetBestMoveWithin(MarkO, 2)
+	assert.Equal(t, r, GameResult{0, Position{2, 2}})
+
+	// an immediate win is always seen
+	g, err = ParseGame("XX1/OO1/X2 O")
+	assert.Nil(t, err)
+	r = g.GetBestMoveWithin(MarkO, 1)
+	assert.Equal(t, r, GameResult{Score, Position{2, 1}})
+
+	// unless no move is looked at
+	r = g.GetBestMoveWithin(MarkO, 0)
+	assert.Equal(t, r, GameResult{0, Position{1, 2}})
+}
+
+func TestIsValidLevel(t *testing.T) {
+	assert.True(t, IsValidLevel(""))
+	assert.True(t, IsValidLevel(LevelBeginner))
+	assert.False(t, IsValidLevel("grandmaster"))
+}
+
 func TestPlayerStatusRepr(t *testing.T) {
 	ps := &PlayerStatus{
 		RoundID:    "round-id",
 		PlayerName: "Adam",
 	}
-	msg := `{"round_id":"round-id","player_name":"Adam","status":"","grid_snap":null}`
+	msg := `{"round_id":"round-id","player_name":"Adam","status":""}`
+	assert.Equal(t, ps.Repr(), msg)
+
+	ps.Mark = MarkX
+	ps.GridSnap = &Grid{{MarkX, MarkO}}
+	msg = `{"round_id":"round-id","player_name":"Adam","mark":1,"status":"",` +
+		`"grid_snap":[[1,2,0],[0,0,0],[0,0,0]]}`
 	assert.Equal(t, ps.Repr(), msg)
 }
@@ -0,0 +1,103 @@
+package ttt
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// Seed of the key table of standard grids, fixed so that hashes can be
+// stored and compared across runs
+const ZobristSeed int64 = 20150601
+
+var errZobristSize = errors.New("Key table is for another board size")
+
+// Random keys for every mark on every cell of a board, and for every
+// mark but the first to be the one to move. The hash of a position is
+// the XOR of the keys of its marks and of the mark to move, so a move
+// changes it by the same XORs whichever way it is made or taken back.
+type ZobristTable struct {
+	Width  int
+	Height int
+	// Marks played on the board, the one moving first first
+	Marks []Mark
+	keys  []uint64
+	sides []uint64
+}
+
+// The key table of standard grids
+var StandardZobrist = NewZobristTable(Size, Size, []Mark{MarkX, MarkO},
+	ZobristSeed)
+
+// A key table drawn from seed: the same seed gives the same keys
+func NewZobristTable(width, height int, marks []Mark, seed int64) *ZobristTable {
+	r := rand.New(rand.NewSource(seed))
+	z := &ZobristTable{
+		Width:  width,
+		Height: height,
+		Marks:  append([]Mark{}, marks...),
+		keys:   make([]uint64, width*height*len(marks)),
+		sides:  make([]uint64, len(marks)),
+	}
+	for i := 1; i < len(z.sides); i++ {
+		z.sides[i] = uint64(r.Int63())<<1 ^ uint64(r.Int63())
+	}
+	for i := range z.keys {
+		z.keys[i] = uint64(r.Int63())<<1 ^ uint64(r.Int63())
+	}
+	return z
+}
+
+// The key of a mark on a cell, 0 for marks the table does not know
+func (z *ZobristTable) Key(p Position, m Mark) uint64 {
+	for i, mark := range z.Marks {
+		if mark == m {
+			return z.keys[(p.Y*z.Width+p.X)*len(z.Marks)+i]
+		}
+	}
+	return 0
+}
+
+// What a move of m at p does to a hash, passing the turn to the next
+// mark
+func (z *ZobristTable) Move(p Position, m Mark) uint64 {
+	for i, mark := range z.Marks {
+		if mark == m {
+			next := z.sides[(i+1)%len(z.Marks)]
+			return z.Key(p, m) ^ z.sides[i] ^ next
+		}
+	}
+	return 0
+}
+
+func (z *ZobristTable) sideKey(toMove Mark) uint64 {
+	for i, mark := range z.Marks {
+		if mark == toMove {
+			return z.sides[i]
+		}
+	}
+	return 0
+}
+
+// The hash of a standard sized grid
+func (z *ZobristTable) HashGrid(g *Grid, toMove Mark) uint64 {
+	h := z.sideKey(toMove)
+	for x, l := range g {
+		for y, m := range l {
+			h ^= z.Key(Position{x, y}, m)
+		}
+	}
+	return h
+}
+
+func (z *ZobristTable) HashBoard(b *Board) uint64 {
+	h := z.sideKey(b.ToMove)
+	for i, m := range b.cells {
+		h ^= z.Key(Position{i % b.Width, i / b.Width}, m)
+	}
+	return h
+}
+
+// The hash of the grid with the standard key table
+func (g *Grid) Hash(toMove Mark) uint64 {
+	return StandardZobrist.HashGrid(g, toMove)
+}
@@ -0,0 +1,75 @@
+package ttt
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewZobristTable(t *testing.T) {
+	marks := []Mark{MarkX, MarkO}
+	a := NewZobristTable(3, 3, marks, 1)
+	assert.Equal(t, NewZobristTable(3, 3, marks, 1), a)
+	assert.NotEqual(t, NewZobristTable(3, 3, marks, 2).keys, a.keys)
+	assert.NotEqual(t, a.Key(Position{0, 0}, MarkX),
+		a.Key(Position{0, 0}, MarkO))
+	assert.Equal(t, a.Key(Position{0, 0}, MarkEmpty), uint64(0))
+}
+
+func TestZobristMoveMarks(t *testing.T) {
+	// a third mark takes its turn after O
+	marks := []Mark{MarkX, MarkO, MarkO + 1}
+	z := NewZobristTable(Size, Size, marks, 1)
+	var g Grid
+	h := z.HashGrid(&g, MarkX)
+	for i, p := range []Position{{0, 0}, {1, 1}, {2, 2}, {0, 2}} {
+		m := marks[i%len(marks)]
+		g.Set(p, m)
+		h ^= z.Move(p, m)
+		assert.Equal(t, h, z.HashGrid(&g, marks[(i+1)%len(marks)]))
+	}
+}
+
+func TestBoardHash(t *testing.T) {
+	b := NewBoard(7, 7, 5)
+	assert.Equal(t, b.UseZobrist(StandardZobrist), errZobristSize)
+	z := NewZobristTable(7, 7, []Mark{MarkX, MarkO}, ZobristSeed)
+	assert.Nil(t, b.UseZobrist(z))
+	assert.Equal(t, b.Hash(), uint64(0))
+
+	// the hash kept up to date is the one worked out from scratch, and
+	// taking all moves back gives the empty board again
+	r := rand.New(rand.NewSource(1))
+	played := []Position{}
+	for !b.IsOver() {
+		moves := b.LegalMoves()
+		p := moves[r.Intn(len(moves))]
+		b.Play(p)
+		played = append(played, p)
+		assert.Equal(t, b.Hash(), z.HashBoard(b))
+	}
+	for i := len(played) - 1; i >= 0; i-- {
+		b.Undo(played[i])
+		assert.Equal(t, b.Hash(), z.HashBoard(b))
+	}
+	assert.Equal(t, b.Hash(), uint64(0))
+}
+
+func TestGridHash(t *testing.T) {
+	// the same cells reached in another order
+	a := NewBoard(Size, Size, Size)
+	a.UseZobrist(StandardZobrist)
+	b := a.Clone()
+	for _, p := range []Position{{0, 0}, {1, 1}, {2, 2}} {
+		a.Play(p)
+	}
+	for _, p := range []Position{{2, 2}, {1, 1}, {0, 0}} {
+		b.Play(p)
+	}
+	assert.Equal(t, a.Hash(), b.Hash())
+
+	g, _ := ParseGame("X2/1O1/2X O")
+	assert.Equal(t, g.Grd.Hash(MarkO), a.Hash())
+	assert.NotEqual(t, g.Grd.Hash(MarkX), a.Hash())
+}